@@ -28,6 +28,18 @@ func Start() error {
 		return err
 	}
 
+	if err := app.GetLockStore(); err != nil {
+		return err
+	}
+
+	if err := app.GetUserInfoStore(); err != nil {
+		return err
+	}
+
+	if err := app.GetTemplateStore(); err != nil {
+		return err
+	}
+
 	if err := app.RegisterDemoApp(ctx); err != nil {
 		return err
 	}
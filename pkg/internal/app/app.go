@@ -3,10 +3,18 @@ package app
 import (
 	"context"
 	"errors"
+	"net/http"
+	"os"
+	"time"
 
 	"github.com/dchest/uniuri"
 	"github.com/owncloud/ocis/v2/ocis-pkg/log"
+	"github.com/wkloucek/cs3-wopi-server/pkg/internal/helpers"
+	"github.com/wkloucek/cs3-wopi-server/pkg/internal/lockstore"
 	"github.com/wkloucek/cs3-wopi-server/pkg/internal/logging"
+	"github.com/wkloucek/cs3-wopi-server/pkg/internal/resilience"
+	"github.com/wkloucek/cs3-wopi-server/pkg/internal/templates"
+	"github.com/wkloucek/cs3-wopi-server/pkg/internal/userinfostore"
 
 	registryv1beta1 "github.com/cs3org/go-cs3apis/cs3/app/registry/v1beta1"
 	gatewayv1beta1 "github.com/cs3org/go-cs3apis/cs3/gateway/v1beta1"
@@ -46,6 +54,57 @@ type WopiApp struct {
 type CS3api struct {
 	GatewayServiceName     string `env:"WOPI_CS3API_GATEWAY_SERVICENAME"`
 	CS3DataGatewayInsecure bool   `env:"WOPI_CS3API_DATA_GATEWAY_INSECURE"`
+	DataGatewayClient
+	Resilience
+}
+
+type DataGatewayClient struct {
+	DialTimeout           time.Duration `env:"WOPI_CS3API_DATA_GATEWAY_DIAL_TIMEOUT"`
+	TLSHandshakeTimeout   time.Duration `env:"WOPI_CS3API_DATA_GATEWAY_TLS_HANDSHAKE_TIMEOUT"`
+	ResponseHeaderTimeout time.Duration `env:"WOPI_CS3API_DATA_GATEWAY_RESPONSE_HEADER_TIMEOUT"` // 0 means no limit
+	IdleConnTimeout       time.Duration `env:"WOPI_CS3API_DATA_GATEWAY_IDLE_CONN_TIMEOUT"`
+	MaxIdleConns          int           `env:"WOPI_CS3API_DATA_GATEWAY_MAX_IDLE_CONNS"` // 0 means no limit
+	MaxIdleConnsPerHost   int           `env:"WOPI_CS3API_DATA_GATEWAY_MAX_IDLE_CONNS_PER_HOST"`
+	MaxConnsPerHost       int           `env:"WOPI_CS3API_DATA_GATEWAY_MAX_CONNS_PER_HOST"` // 0 means no limit
+}
+
+type Resilience struct {
+	RetryAttempts    int           `env:"WOPI_CS3API_RETRY_ATTEMPTS"`
+	RetryBackoff     time.Duration `env:"WOPI_CS3API_RETRY_BACKOFF"`
+	RetryMaxBackoff  time.Duration `env:"WOPI_CS3API_RETRY_MAX_BACKOFF"`
+	BreakerThreshold int           `env:"WOPI_CS3API_CIRCUIT_BREAKER_THRESHOLD"` // 0 disables the circuit breaker
+	BreakerTimeout   time.Duration `env:"WOPI_CS3API_CIRCUIT_BREAKER_TIMEOUT"`
+}
+
+type Web struct {
+	URL string `env:"WOPI_WEB_URL"` // public url of ownCloud Web, eg. https://ocis.owncloud.test
+}
+
+type LockStore struct {
+	Backend string `env:"WOPI_LOCK_STORE"`      // "cs3", "local" or "auto" (cs3 with a fallback to local)
+	File    string `env:"WOPI_LOCK_STORE_FILE"` // persists the locks of the local lock store, if set
+}
+
+type UserInfoStore struct {
+	File string `env:"WOPI_USER_INFO_STORE_FILE"` // persists the UserInfo strings, if set
+}
+
+type Templates struct {
+	Dir    string `env:"WOPI_TEMPLATES_DIR"`    // overrides the built-in blank templates with "blank.<extension>" files, if set
+	Folder string `env:"WOPI_TEMPLATES_FOLDER"` // resource id of the CS3 folder with the template library, eg. "storageid$spaceid!opaqueid"
+}
+
+type Collabora struct {
+	PostMessageOrigin       string `env:"WOPI_COLLABORA_POST_MESSAGE_ORIGIN"` // origin of the host page, defaults to the origin of the web url
+	HidePrintOption         bool   `env:"WOPI_COLLABORA_HIDE_PRINT_OPTION"`
+	HideSaveOption          bool   `env:"WOPI_COLLABORA_HIDE_SAVE_OPTION"`
+	HideExportOption        bool   `env:"WOPI_COLLABORA_HIDE_EXPORT_OPTION"`
+	AvatarURL               string `env:"WOPI_COLLABORA_AVATAR_URL"`     // url of the user avatars, "{user_id}" is replaced with the id of the user
+	WatermarkText           string `env:"WOPI_COLLABORA_WATERMARK_TEXT"` // "{user}" and "{email}" are replaced with the name and email of the user
+	EnableInsertRemoteImage bool   `env:"WOPI_COLLABORA_ENABLE_INSERT_REMOTE_IMAGE"`
+	DisableInactiveMessages bool   `env:"WOPI_COLLABORA_DISABLE_INACTIVE_MESSAGES"`
+	LockGuestUsers          bool   `env:"WOPI_COLLABORA_LOCK_GUEST_USERS"` // locks the features of anonymous and public link users
+	SaveAsPostmessage       bool   `env:"WOPI_COLLABORA_SAVE_AS_POSTMESSAGE"`
 }
 
 type Config struct {
@@ -54,6 +113,11 @@ type Config struct {
 	HTTP
 	WopiApp
 	CS3api
+	Web
+	LockStore
+	UserInfoStore
+	Templates
+	Collabora
 
 	WopiSecret     string `env:"WOPI_SECRET"` // used as jwt secret and to encrypt access tokens
 	AppName        string `env:"WOPI_APP_NAME"`
@@ -63,8 +127,15 @@ type Config struct {
 }
 
 type demoApp struct {
-	gwc        gatewayv1beta1.GatewayAPIClient
-	grpcServer *grpc.Server
+	gwc               gatewayv1beta1.GatewayAPIClient
+	dataGatewayClient *http.Client
+	cs3Policy         *resilience.Policy
+	grpcServer        *grpc.Server
+	lockStore         lockstore.Store
+	lockSessions      lockSessions
+	userInfoStore     userinfostore.Store
+	templateStore     *templates.Store
+	templateLibrary   *templates.Library
 
 	appURLs map[string]map[string]string
 
@@ -84,6 +155,24 @@ func New() (*demoApp, error) {
 			CS3api: CS3api{
 				GatewayServiceName:     "com.owncloud.api.gateway",
 				CS3DataGatewayInsecure: true, // TODO: this should have a secure default
+				DataGatewayClient: DataGatewayClient{
+					DialTimeout:           10 * time.Second,
+					TLSHandshakeTimeout:   10 * time.Second,
+					ResponseHeaderTimeout: 2 * time.Minute,
+					IdleConnTimeout:       90 * time.Second,
+					MaxIdleConns:          100,
+					MaxIdleConnsPerHost:   16,
+				},
+				Resilience: Resilience{
+					RetryAttempts:    3,
+					RetryBackoff:     200 * time.Millisecond,
+					RetryMaxBackoff:  2 * time.Second,
+					BreakerThreshold: 5,
+					BreakerTimeout:   30 * time.Second,
+				},
+			},
+			LockStore: LockStore{
+				Backend: "auto",
 			},
 			Service: Service{
 				Namespace: "com.github.wkloucek.cs3-wopi-server",
@@ -122,7 +211,93 @@ func (app *demoApp) GetCS3apiClient() error {
 	if err != nil {
 		return err
 	}
-	app.gwc = gwc
+
+	// transient failures of the gateway and the data gateway are retried, while they are down the calls fail fast
+	app.cs3Policy = resilience.NewPolicy(resilience.Options{
+		RetryAttempts:    app.Config.CS3api.Resilience.RetryAttempts,
+		RetryBackoff:     app.Config.CS3api.Resilience.RetryBackoff,
+		RetryMaxBackoff:  app.Config.CS3api.Resilience.RetryMaxBackoff,
+		BreakerThreshold: app.Config.CS3api.Resilience.BreakerThreshold,
+		BreakerTimeout:   app.Config.CS3api.Resilience.BreakerTimeout,
+	})
+	app.gwc = resilience.NewGatewayClient(gwc, app.cs3Policy)
+
+	// the data gateway client is shared by all downloads and uploads to reuse connections
+	app.dataGatewayClient = helpers.NewHTTPClient(helpers.HTTPClientOptions{
+		Insecure:              app.Config.CS3api.CS3DataGatewayInsecure,
+		DialTimeout:           app.Config.CS3api.DataGatewayClient.DialTimeout,
+		TLSHandshakeTimeout:   app.Config.CS3api.DataGatewayClient.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: app.Config.CS3api.DataGatewayClient.ResponseHeaderTimeout,
+		IdleConnTimeout:       app.Config.CS3api.DataGatewayClient.IdleConnTimeout,
+		MaxIdleConns:          app.Config.CS3api.DataGatewayClient.MaxIdleConns,
+		MaxIdleConnsPerHost:   app.Config.CS3api.DataGatewayClient.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       app.Config.CS3api.DataGatewayClient.MaxConnsPerHost,
+		Policy:                app.cs3Policy,
+	})
+
+	return nil
+}
+
+func (app *demoApp) GetLockStore() error {
+	switch app.Config.LockStore.Backend {
+	case "cs3":
+		app.lockStore = lockstore.NewCS3(app.gwc)
+
+	case "local":
+		localStore, err := lockstore.NewLocal(app.Config.LockStore.File)
+		if err != nil {
+			return err
+		}
+		app.lockStore = localStore
+
+	case "auto":
+		localStore, err := lockstore.NewLocal(app.Config.LockStore.File)
+		if err != nil {
+			return err
+		}
+		app.lockStore = lockstore.NewFallback(lockstore.NewCS3(app.gwc), localStore, app.Logger)
+
+	default:
+		return errors.New("unknown lock store backend: " + app.Config.LockStore.Backend)
+	}
+
+	return nil
+}
+
+func (app *demoApp) GetUserInfoStore() error {
+	if app.Config.UserInfoStore.File == "" {
+		// WOPI clients rely on the UserInfo strings to be kept, eg. for the first start experience of a user
+		app.Logger.Warn().Msg("GetUserInfoStore: WOPI_USER_INFO_STORE_FILE is not set, the UserInfo strings are lost on restarts")
+	}
+
+	userInfoStore, err := userinfostore.NewLocal(app.Config.UserInfoStore.File)
+	if err != nil {
+		return err
+	}
+	app.userInfoStore = userInfoStore
+
+	return nil
+}
+
+func (app *demoApp) GetTemplateStore() error {
+	if app.Config.Templates.Dir != "" {
+		info, err := os.Stat(app.Config.Templates.Dir)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return errors.New("template directory is not a directory: " + app.Config.Templates.Dir)
+		}
+	}
+	app.templateStore = templates.New(app.Config.Templates.Dir)
+
+	if app.Config.Templates.Folder != "" {
+		folderID, err := parseResourceID(app.Config.Templates.Folder)
+		if err != nil {
+			return errors.New("invalid template library folder: " + app.Config.Templates.Folder)
+		}
+		app.templateLibrary = templates.NewLibrary(app.gwc, folderID)
+	}
 
 	return nil
 }
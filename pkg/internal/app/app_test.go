@@ -0,0 +1,164 @@
+package app
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	appproviderv1beta1 "github.com/cs3org/go-cs3apis/cs3/app/provider/v1beta1"
+	gatewayv1beta1 "github.com/cs3org/go-cs3apis/cs3/gateway/v1beta1"
+	userv1beta1 "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	rpcv1beta1 "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
+	providerv1beta1 "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	"github.com/golang-jwt/jwt"
+	"github.com/owncloud/ocis/v2/ocis-pkg/log"
+	"github.com/wkloucek/cs3-wopi-server/pkg/internal/lockstore"
+	"github.com/wkloucek/cs3-wopi-server/pkg/internal/resilience"
+	"github.com/wkloucek/cs3-wopi-server/pkg/internal/templates"
+	"github.com/wkloucek/cs3-wopi-server/pkg/internal/userinfostore"
+	"google.golang.org/grpc"
+)
+
+// fakeGateway answers the stat and the file versions calls of the gateway for a single file,
+// all other calls panic
+type fakeGateway struct {
+	gatewayv1beta1.GatewayAPIClient
+
+	info     *providerv1beta1.ResourceInfo
+	versions []*providerv1beta1.FileVersion
+	restored []string
+	// restoreStatus is the status of the restores, if they shouldn't succeed
+	restoreStatus rpcv1beta1.Code
+}
+
+func (g *fakeGateway) Stat(ctx context.Context, req *providerv1beta1.StatRequest, opts ...grpc.CallOption) (*providerv1beta1.StatResponse, error) {
+	return &providerv1beta1.StatResponse{
+		Status: &rpcv1beta1.Status{Code: rpcv1beta1.Code_CODE_OK},
+		Info:   g.info,
+	}, nil
+}
+
+func (g *fakeGateway) ListFileVersions(ctx context.Context, req *providerv1beta1.ListFileVersionsRequest, opts ...grpc.CallOption) (*providerv1beta1.ListFileVersionsResponse, error) {
+	return &providerv1beta1.ListFileVersionsResponse{
+		Status:   &rpcv1beta1.Status{Code: rpcv1beta1.Code_CODE_OK},
+		Versions: g.versions,
+	}, nil
+}
+
+func (g *fakeGateway) RestoreFileVersion(ctx context.Context, req *providerv1beta1.RestoreFileVersionRequest, opts ...grpc.CallOption) (*providerv1beta1.RestoreFileVersionResponse, error) {
+	if g.restoreStatus != rpcv1beta1.Code_CODE_INVALID {
+		return &providerv1beta1.RestoreFileVersionResponse{
+			Status: &rpcv1beta1.Status{Code: g.restoreStatus},
+		}, nil
+	}
+	g.restored = append(g.restored, req.Key)
+	return &providerv1beta1.RestoreFileVersionResponse{
+		Status: &rpcv1beta1.Status{Code: rpcv1beta1.Code_CODE_OK},
+	}, nil
+}
+
+// newTestApp returns an app for a WOPI server on https://wopi.example.com using the gateway
+func newTestApp(t *testing.T, gwc gatewayv1beta1.GatewayAPIClient) *demoApp {
+	t.Helper()
+
+	lockStore, err := lockstore.NewLocal("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userInfoStore, err := userinfostore.NewLocal("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &demoApp{
+		gwc:           gwc,
+		cs3Policy:     resilience.NewPolicy(resilience.Options{}),
+		lockStore:     lockStore,
+		userInfoStore: userInfoStore,
+		templateStore: templates.New(""),
+		appURLs: map[string]map[string]string{
+			"view": {".docx": "https://office.example.com/view"},
+			"edit": {".docx": "https://office.example.com/edit"},
+		},
+		Config: Config{
+			WopiSecret: "wopi-secret",
+			HTTP: HTTP{
+				Addr:   "wopi.example.com",
+				Scheme: "https",
+			},
+		},
+		Logger: log.NopLogger(),
+	}
+}
+
+// testFileInfo returns a writable file with versions
+func testFileInfo() *providerv1beta1.ResourceInfo {
+	return &providerv1beta1.ResourceInfo{
+		Id: &providerv1beta1.ResourceId{
+			StorageId: "storage",
+			SpaceId:   "space",
+			OpaqueId:  "file",
+		},
+		Path: "./report.docx",
+		Owner: &userv1beta1.UserId{
+			Idp:      "https://idp.example.com",
+			OpaqueId: "einstein",
+			Type:     userv1beta1.UserType_USER_TYPE_PRIMARY,
+		},
+		Type: providerv1beta1.ResourceType_RESOURCE_TYPE_FILE,
+		PermissionSet: &providerv1beta1.ResourcePermissions{
+			InitiateFileDownload: true,
+			InitiateFileUpload:   true,
+			ListFileVersions:     true,
+			RestoreFileVersion:   true,
+			Stat:                 true,
+		},
+	}
+}
+
+// newTestWopiContext returns a read-write wopi context for the file, with a CS3 token valid for an hour
+func newTestWopiContext(t *testing.T, info *providerv1beta1.ResourceInfo) WopiContext {
+	t.Helper()
+
+	cs3Token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.StandardClaims{
+		ExpiresAt: time.Now().Add(time.Hour).Unix(),
+	}).SignedString([]byte("cs3-secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return WopiContext{
+		AccessToken: cs3Token,
+		FileReference: providerv1beta1.Reference{
+			ResourceId: info.Id,
+			Path:       ".",
+		},
+		User: &userv1beta1.User{
+			Id: &userv1beta1.UserId{
+				Idp:      "https://idp.example.com",
+				OpaqueId: "einstein",
+				Type:     userv1beta1.UserType_USER_TYPE_PRIMARY,
+			},
+			Username:    "einstein",
+			DisplayName: "Albert Einstein",
+		},
+		ViewMode: appproviderv1beta1.OpenInAppRequest_VIEW_MODE_READ_WRITE,
+	}
+}
+
+// wopiURL returns the url of the path below the WOPISrc of the file with the access token
+func wopiURL(t *testing.T, app *demoApp, wopiContext WopiContext, subPath string) string {
+	t.Helper()
+
+	accessToken, _, err := app.newAccessToken(wopiContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u := app.wopiSrcURL(fileRefFromResourceID(wopiContext.FileReference.ResourceId))
+	u.Path += subPath
+	u.RawQuery = url.Values{"access_token": []string{accessToken}}.Encode()
+	return u.String()
+}
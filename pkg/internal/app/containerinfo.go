@@ -0,0 +1,19 @@
+package app
+
+type ContainerInfo struct {
+	// ------------
+	// Microsoft WOPI check container info specification:
+	// https://docs.microsoft.com/en-us/microsoft-365/cloud-storage-partner-program/rest/containers/checkcontainerinfo
+	// ------------
+
+	// The name of the container without a path.
+	Name string `json:"Name"`
+	// A Boolean value that indicates the user has permission to create a new container in the container.
+	UserCanCreateChildContainer bool `json:"UserCanCreateChildContainer"`
+	// A Boolean value that indicates the user has permission to create a new file in the container.
+	UserCanCreateChildFile bool `json:"UserCanCreateChildFile"`
+	// A Boolean value that indicates the user has permission to delete the container.
+	UserCanDelete bool `json:"UserCanDelete"`
+	// A Boolean value that indicates the user has permission to rename the container.
+	UserCanRename bool `json:"UserCanRename"`
+}
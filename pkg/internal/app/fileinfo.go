@@ -138,4 +138,33 @@ type FileInfo struct {
 	DisableExport bool `json:"DisableExport,omitempty"`
 	// Disables copying from the document in libreoffice online backend. Pasting into the document would still be possible. However, it is still possible to do an “internal” cut/copy/paste.
 	DisableCopy bool `json:"DisableCopy,omitempty"`
+	// If set, the document is created from the template at this URL. The WOPI host sets it for empty files, the WOPI client saves the new document with PutFile.
+	TemplateSource string `json:"TemplateSource,omitempty"`
+	// The origin of the host page, eg. https://ocis.owncloud.test. The WOPI client sends post messages to the host page only if it is set.
+	PostMessageOrigin string `json:"PostMessageOrigin,omitempty"`
+	// Hides the print option from the file menu bar in the UI.
+	HidePrintOption bool `json:"HidePrintOption,omitempty"`
+	// Hides the save button from the toolbar and file menubar in the UI.
+	HideSaveOption bool `json:"HideSaveOption,omitempty"`
+	// Hides the download as option in the file menubar.
+	HideExportOption bool `json:"HideExportOption,omitempty"`
+	// Additional information about the user, which is shown to the other users of the document, eg. the avatar.
+	UserExtraInfo *UserExtraInfo `json:"UserExtraInfo,omitempty"`
+	// If set to a non-empty string, a watermark with this text is shown on all pages of the document.
+	WatermarkText string `json:"WatermarkText,omitempty"`
+	// If set to true, the user can insert images from the host with the "Insert Remote Image" option, the host page handles the UI_InsertGraphic post message.
+	EnableInsertRemoteImage bool `json:"EnableInsertRemoteImage,omitempty"`
+	// If set to true, the "Inactive" message is not shown to users who were idle for a while.
+	DisableInactiveMessages bool `json:"DisableInactiveMessages,omitempty"`
+	// If set to true, the features locked for the user are disabled, eg. for guest users.
+	IsUserLocked bool `json:"IsUserLocked,omitempty"`
+	// If set to true, the WOPI client sends a UI_SaveAs post message to the host page instead of showing its own "Save As" dialog.
+	SaveAsPostmessage bool `json:"SaveAsPostmessage,omitempty"`
+}
+
+type UserExtraInfo struct {
+	// A URI to the avatar of the user.
+	Avatar string `json:"avatar,omitempty"`
+	// The email address of the user.
+	Mail string `json:"mail,omitempty"`
 }
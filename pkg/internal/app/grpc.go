@@ -53,14 +53,85 @@ func (app *demoApp) OpenInApp(
 
 	// build a urlsafe and stable file reference that can be used for proxy routing,
 	// so that all sessions on one file end on the same office server
-
-	c := sha256.New()
-	c.Write([]byte(req.ResourceInfo.Id.StorageId + "$" + req.ResourceInfo.Id.SpaceId + "!" + req.ResourceInfo.Id.OpaqueId))
-	fileRef := hex.EncodeToString(c.Sum(nil))
+	fileRef := fileRefFromResourceID(req.ResourceInfo.Id)
 
 	// get the file extension to use the right wopi app url
 	fileExt := path.Ext(req.GetResourceInfo().Path)
 
+	viewAppURL, editAppURL, err := app.appURLsForFile(fileExt, fileRef)
+	if err != nil {
+		return nil, err
+	}
+
+	appURL := viewAppURL
+	if req.ViewMode == appproviderv1beta1.OpenInAppRequest_VIEW_MODE_READ_WRITE {
+		appURL = editAppURL
+	}
+
+	wopiContext := WopiContext{
+		AccessToken: req.AccessToken,
+		FileReference: providerv1beta1.Reference{
+			ResourceId: req.GetResourceInfo().Id,
+			Path:       ".",
+		},
+		User:     user,
+		ViewMode: req.ViewMode,
+
+		EditAppUrl: editAppURL,
+		ViewAppUrl: viewAppURL,
+		Collabora:  openInAppCollaboraSession(req),
+	}
+
+	accessToken, accessTokenExpiresAt, err := app.newAccessToken(wopiContext)
+	if err != nil {
+		return &appproviderv1beta1.OpenInAppResponse{
+			Status: &rpcv1beta1.Status{Code: rpcv1beta1.Code_CODE_INTERNAL},
+		}, err
+	}
+
+	if versionKey := openInAppVersionKey(req); versionKey != "" {
+		// previous versions of the file are always opened read-only
+		appURL, accessToken, accessTokenExpiresAt, err = app.newVersionSession(wopiContext, req.GetResourceInfo(), versionKey)
+		if err != nil {
+			return &appproviderv1beta1.OpenInAppResponse{
+				Status: &rpcv1beta1.Status{Code: rpcv1beta1.Code_CODE_INTERNAL},
+			}, err
+		}
+	}
+
+	return &appproviderv1beta1.OpenInAppResponse{
+		Status: &rpcv1beta1.Status{Code: rpcv1beta1.Code_CODE_OK},
+		AppUrl: &appproviderv1beta1.OpenInAppURL{
+			AppUrl: appURL,
+			Method: "POST",
+			FormParameters: map[string]string{
+				// these parameters will be passed to the web server by the app provider application
+				"access_token": accessToken,
+				// milliseconds since Jan 1, 1970 UTC as required in https://docs.microsoft.com/en-us/microsoft-365/cloud-storage-partner-program/rest/concepts#access_token_ttl
+				"access_token_ttl": strconv.FormatInt(accessTokenExpiresAt*1000, 10),
+			},
+		},
+	}, nil
+}
+
+// fileRefFromResourceID builds a urlsafe and stable file reference from a resource id
+func fileRefFromResourceID(id *providerv1beta1.ResourceId) string {
+	c := sha256.New()
+	c.Write([]byte(id.StorageId + "$" + id.SpaceId + "!" + id.OpaqueId))
+	return hex.EncodeToString(c.Sum(nil))
+}
+
+// wopiSrcURL returns the WOPISrc for a file reference
+func (app *demoApp) wopiSrcURL(fileRef string) url.URL {
+	return url.URL{
+		Scheme: app.Config.HTTP.Scheme,
+		Host:   app.Config.HTTP.Addr,
+		Path:   path.Join("wopi", "files", fileRef),
+	}
+}
+
+// appURLsForFile returns the view and edit app url for a file extension, including the WOPISrc of the file reference
+func (app *demoApp) appURLsForFile(fileExt string, fileRef string) (string, string, error) {
 	var viewAppURL string
 	var editAppURL string
 	if viewAppURLs, ok := app.appURLs["view"]; ok {
@@ -82,11 +153,7 @@ func (app *demoApp) OpenInApp(
 		editAppURL = viewAppURL
 	}
 
-	wopiSrcURL := url.URL{
-		Scheme: app.Config.HTTP.Scheme,
-		Host:   app.Config.HTTP.Addr,
-		Path:   path.Join("wopi", "files", fileRef),
-	}
+	wopiSrcURL := app.wopiSrcURL(fileRef)
 
 	addWopiSrcQueryParam := func(baseURL string) (string, error) {
 		u, err := url.Parse(baseURL)
@@ -102,46 +169,33 @@ func (app *demoApp) OpenInApp(
 		return u.String(), nil
 	}
 
-	viewAppURL, err = addWopiSrcQueryParam(viewAppURL)
+	viewAppURL, err := addWopiSrcQueryParam(viewAppURL)
 	if err != nil {
-		return nil, err
+		return "", "", err
 	}
 	editAppURL, err = addWopiSrcQueryParam(editAppURL)
 	if err != nil {
-		return nil, err
+		return "", "", err
 	}
 
-	appURL := viewAppURL
-	if req.ViewMode == appproviderv1beta1.OpenInAppRequest_VIEW_MODE_READ_WRITE {
-		appURL = editAppURL
-	}
+	return viewAppURL, editAppURL, nil
+}
 
-	cryptedReqAccessToken, err := EncryptAES([]byte(app.Config.WopiSecret), req.AccessToken)
+// newAccessToken signs a WOPI access token for the wopi context. The token expires together with
+// the CS3 access token of the wopi context, which will be encrypted in the WOPI access token.
+func (app *demoApp) newAccessToken(wopiContext WopiContext) (string, int64, error) {
+	cs3Claims := &jwt.StandardClaims{}
+	cs3JWTparser := jwt.Parser{}
+	_, _, err := cs3JWTparser.ParseUnverified(wopiContext.AccessToken, cs3Claims)
 	if err != nil {
-		return &appproviderv1beta1.OpenInAppResponse{
-			Status: &rpcv1beta1.Status{Code: rpcv1beta1.Code_CODE_INTERNAL},
-		}, err
-	}
-
-	wopiContext := WopiContext{
-		AccessToken: cryptedReqAccessToken,
-		FileReference: providerv1beta1.Reference{
-			ResourceId: req.GetResourceInfo().Id,
-			Path:       ".",
-		},
-		User:     user,
-		ViewMode: req.ViewMode,
-
-		EditAppUrl: editAppURL,
-		ViewAppUrl: viewAppURL,
+		return "", 0, err
 	}
 
-	cs3Claims := &jwt.StandardClaims{}
-	cs3JWTparser := jwt.Parser{}
-	_, _, err = cs3JWTparser.ParseUnverified(req.AccessToken, cs3Claims)
+	cryptedAccessToken, err := EncryptAES([]byte(app.Config.WopiSecret), wopiContext.AccessToken)
 	if err != nil {
-		return nil, err
+		return "", 0, err
 	}
+	wopiContext.AccessToken = cryptedAccessToken
 
 	claims := &Claims{
 		WopiContext: wopiContext,
@@ -152,24 +206,42 @@ func (app *demoApp) OpenInApp(
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	accessToken, err := token.SignedString([]byte(app.Config.WopiSecret))
-
 	if err != nil {
-		return &appproviderv1beta1.OpenInAppResponse{
-			Status: &rpcv1beta1.Status{Code: rpcv1beta1.Code_CODE_INTERNAL},
-		}, err
+		return "", 0, err
 	}
 
-	return &appproviderv1beta1.OpenInAppResponse{
-		Status: &rpcv1beta1.Status{Code: rpcv1beta1.Code_CODE_OK},
-		AppUrl: &appproviderv1beta1.OpenInAppURL{
-			AppUrl: appURL,
-			Method: "POST",
-			FormParameters: map[string]string{
-				// these parameters will be passed to the web server by the app provider application
-				"access_token": accessToken,
-				// milliseconds since Jan 1, 1970 UTC as required in https://docs.microsoft.com/en-us/microsoft-365/cloud-storage-partner-program/rest/concepts#access_token_ttl
-				"access_token_ttl": strconv.FormatInt(claims.ExpiresAt*1000, 10),
-			},
-		},
-	}, nil
+	return accessToken, claims.ExpiresAt, nil
+}
+
+// openInAppVersionKey returns the key of the file version that should be opened, which can be passed
+// as "version_key" in the opaque of the OpenInApp request. It's empty for the current version.
+func openInAppVersionKey(req *appproviderv1beta1.OpenInAppRequest) string {
+	return openInAppOpaqueValue(req, "version_key")
+}
+
+// openInAppCollaboraSession returns the Collabora options of the session, which can be passed in the opaque of
+// the OpenInApp request, eg. "collabora_watermark_text". Boolean options are enabled with "true".
+func openInAppCollaboraSession(req *appproviderv1beta1.OpenInAppRequest) CollaboraSession {
+	enabled := func(key string) bool {
+		value, _ := strconv.ParseBool(openInAppOpaqueValue(req, key))
+		return value
+	}
+
+	return CollaboraSession{
+		HidePrintOption:         enabled("collabora_hide_print_option"),
+		HideSaveOption:          enabled("collabora_hide_save_option"),
+		HideExportOption:        enabled("collabora_hide_export_option"),
+		WatermarkText:           openInAppOpaqueValue(req, "collabora_watermark_text"),
+		DisableInactiveMessages: enabled("collabora_disable_inactive_messages"),
+		SaveAsPostmessage:       enabled("collabora_save_as_postmessage"),
+	}
+}
+
+// openInAppOpaqueValue returns the plain value of the key in the opaque of the OpenInApp request
+func openInAppOpaqueValue(req *appproviderv1beta1.OpenInAppRequest, key string) string {
+	entry, ok := req.GetOpaque().GetMap()[key]
+	if !ok || entry.GetDecoder() != "plain" {
+		return ""
+	}
+	return string(entry.GetValue())
 }
@@ -1,5 +1,29 @@
 package app
 
 const (
-	HeaderWopiLock string = "X-WOPI-Lock"
+	HeaderWopiLock              string = "X-WOPI-Lock"
+	HeaderWopiOldLock           string = "X-WOPI-OldLock"
+	HeaderWopiLockFailureReason string = "X-WOPI-LockFailureReason"
+
+	HeaderWopiSuggestedTarget         string = "X-WOPI-SuggestedTarget"
+	HeaderWopiRelativeTarget          string = "X-WOPI-RelativeTarget"
+	HeaderWopiOverwriteRelativeTarget string = "X-WOPI-OverwriteRelativeTarget"
+	HeaderWopiValidRelativeTarget     string = "X-WOPI-ValidRelativeTarget"
+
+	HeaderWopiRequestedName        string = "X-WOPI-RequestedName"
+	HeaderWopiInvalidFileNameError string = "X-WOPI-InvalidFileNameError"
+
+	HeaderWopiInvalidContainerNameError string = "X-WOPI-InvalidContainerNameError"
+
+	HeaderWopiUrlType string = "X-WOPI-UrlType"
+
+	HeaderWopiMaxExpectedSize string = "X-WOPI-MaxExpectedSize"
+	HeaderWopiItemVersion     string = "X-WOPI-ItemVersion"
+
+	// Collabora specific headers
+	HeaderCoolWopiTimestamp string = "X-COOL-WOPI-Timestamp"
+	HeaderLoolWopiTimestamp string = "X-LOOL-WOPI-Timestamp"
+
+	HeaderCoolWopiIsModifiedByUser string = "X-COOL-WOPI-IsModifiedByUser"
+	HeaderLoolWopiIsModifiedByUser string = "X-LOOL-WOPI-IsModifiedByUser"
 )
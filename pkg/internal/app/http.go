@@ -2,15 +2,30 @@ package app
 
 import (
 	"context"
+	"errors"
 	"net/http"
 
 	"github.com/go-chi/chi"
 	"github.com/wkloucek/cs3-wopi-server/pkg/internal/middleware"
+	"github.com/wkloucek/cs3-wopi-server/pkg/internal/resilience"
 )
 
 func (app *demoApp) HTTPServer(ctx context.Context) error {
 	// start a simple web server that will get requests from
 	// app provider client, eg. ownCloud Web
+	r := app.router()
+
+	go func() {
+		if err := http.ListenAndServe(app.Config.HTTP.BindAddr, r); err != nil {
+			app.Logger.Error().Err(err).Msg("HTTP server error")
+		}
+	}()
+
+	return nil
+}
+
+// router returns the handler for the WOPI endpoints
+func (app *demoApp) router() http.Handler {
 	r := chi.NewRouter()
 
 	r.Use(middleware.AccessLog(app.Logger))
@@ -21,6 +36,28 @@ func (app *demoApp) HTTPServer(ctx context.Context) error {
 			WopiInfoHandler(app, w, r)
 		})
 
+		// the versions page is opened in the browser, it has its own access tokens
+		r.Route("/files/{fileid}/versions", func(r chi.Router) {
+
+			r.Use(func(h http.Handler) http.Handler {
+				// authentication and wopi context
+				return WopiVersionsContextAuthMiddleware(app, h)
+			},
+			)
+
+			r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+				FileVersions(app, w, r)
+			})
+
+			r.Post("/", func(w http.ResponseWriter, r *http.Request) {
+				RestoreFileVersion(app, w, r)
+			})
+
+			r.Post("/open", func(w http.ResponseWriter, r *http.Request) {
+				OpenFileVersion(app, w, r)
+			})
+		})
+
 		r.Route("/files/{fileid}", func(r chi.Router) {
 
 			r.Use(func(h http.Handler) http.Handler {
@@ -29,10 +66,25 @@ func (app *demoApp) HTTPServer(ctx context.Context) error {
 			},
 			)
 
+			// file versions are opened read-only
+			r.Use(VersionReadOnlyMiddleware)
+
 			r.Get("/", func(w http.ResponseWriter, r *http.Request) {
 				CheckFileInfo(app, w, r)
 			})
 
+			r.Get("/ancestry", func(w http.ResponseWriter, r *http.Request) {
+				EnumerateAncestors(app, w, r)
+			})
+
+			r.Get("/ecosystem_pointer", func(w http.ResponseWriter, r *http.Request) {
+				GetEcosystem(app, w, r)
+			})
+
+			r.Get("/template", func(w http.ResponseWriter, r *http.Request) {
+				FileTemplate(app, w, r)
+			})
+
 			r.Post("/", func(w http.ResponseWriter, r *http.Request) {
 				action := r.Header.Get("X-WOPI-Override")
 				switch action {
@@ -46,18 +98,17 @@ func (app *demoApp) HTTPServer(ctx context.Context) error {
 				case "UNLOCK":
 					UnLock(app, w, r)
 
+				case "GET_SHARE_URL":
+					GetShareUrl(app, w, r)
+
 				case "PUT_USER_INFO":
-					// https://docs.microsoft.com/en-us/microsoft-365/cloud-storage-partner-program/rest/files/putuserinfo
-					http.Error(w, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
+					PutUserInfo(app, w, r)
 				case "PUT_RELATIVE":
-					// https://docs.microsoft.com/en-us/microsoft-365/cloud-storage-partner-program/rest/files/putrelativefile
-					http.Error(w, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
+					PutRelativeFile(app, w, r)
 				case "RENAME_FILE":
-					// https://docs.microsoft.com/en-us/microsoft-365/cloud-storage-partner-program/rest/files/renamefile
-					http.Error(w, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
+					RenameFile(app, w, r)
 				case "DELETE":
-					// https://docs.microsoft.com/en-us/microsoft-365/cloud-storage-partner-program/rest/files/deletefile
-					http.Error(w, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
+					DeleteFile(app, w, r)
 
 				default:
 					http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
@@ -82,13 +133,103 @@ func (app *demoApp) HTTPServer(ctx context.Context) error {
 				})
 			})
 		})
+
+		r.Route("/containers/{containerid}", func(r chi.Router) {
+
+			r.Use(func(h http.Handler) http.Handler {
+				// authentication and wopi context
+				return WopiContainerContextAuthMiddleware(app, h)
+			},
+			)
+
+			r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+				CheckContainerInfo(app, w, r)
+			})
+
+			r.Get("/children", func(w http.ResponseWriter, r *http.Request) {
+				EnumerateChildren(app, w, r)
+			})
+
+			r.Get("/ancestry", func(w http.ResponseWriter, r *http.Request) {
+				EnumerateContainerAncestors(app, w, r)
+			})
+
+			r.Get("/templates", func(w http.ResponseWriter, r *http.Request) {
+				EnumerateTemplates(app, w, r)
+			})
+
+			r.Post("/templates", func(w http.ResponseWriter, r *http.Request) {
+				CreateChildFileFromTemplate(app, w, r)
+			})
+
+			r.Get("/ecosystem_pointer", func(w http.ResponseWriter, r *http.Request) {
+				GetEcosystem(app, w, r)
+			})
+
+			r.Post("/", func(w http.ResponseWriter, r *http.Request) {
+				action := r.Header.Get("X-WOPI-Override")
+				switch action {
+
+				case "CREATE_CHILD_CONTAINER":
+					CreateChildContainer(app, w, r)
+				case "CREATE_CHILD_FILE":
+					CreateChildFile(app, w, r)
+				case "DELETE_CONTAINER":
+					DeleteContainer(app, w, r)
+				case "RENAME_CONTAINER":
+					RenameContainer(app, w, r)
+
+				default:
+					http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				}
+			})
+		})
+
+		r.Route("/ecosystem", func(r chi.Router) {
+
+			r.Use(func(h http.Handler) http.Handler {
+				// authentication and wopi context
+				return WopiEcosystemContextAuthMiddleware(app, h)
+			},
+			)
+
+			r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+				CheckEcosystem(app, w, r)
+			})
+
+			r.Get("/root_container_pointer", func(w http.ResponseWriter, r *http.Request) {
+				GetRootContainer(app, w, r)
+			})
+
+			r.Post("/", func(w http.ResponseWriter, r *http.Request) {
+				action := r.Header.Get("X-WOPI-Override")
+				switch action {
+
+				case "GET_FILE_WOPI_SRC":
+					GetFileWopiSrc(app, w, r)
+
+				default:
+					http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				}
+			})
+		})
 	})
 
-	go func() {
-		if err := http.ListenAndServe(app.Config.HTTP.BindAddr, r); err != nil {
-			app.Logger.Error().Err(err).Msg("HTTP server error")
-		}
-	}()
+	return r
+}
 
-	return nil
+// notAuthorized responds to operations the user isn't authorized to do. WOPI expects 404 Not Found then,
+// 401 Unauthorized would tell the WOPI client that the access token is invalid.
+func notAuthorized(w http.ResponseWriter) {
+	http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+}
+
+// cs3Error responds to a failed call to the gateway or the data gateway. While they are down,
+// the WOPI client is told that the service is unavailable, so that it can try again later.
+func cs3Error(w http.ResponseWriter, err error) {
+	if errors.Is(err, resilience.ErrCircuitOpen) {
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return
+	}
+	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 }
@@ -0,0 +1,45 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	providerv1beta1 "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	"github.com/wkloucek/cs3-wopi-server/pkg/internal/resilience"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// unavailableGateway is a gateway that can't be reached
+type unavailableGateway struct {
+	fakeGateway
+}
+
+func (g *unavailableGateway) Stat(ctx context.Context, req *providerv1beta1.StatRequest, opts ...grpc.CallOption) (*providerv1beta1.StatResponse, error) {
+	return nil, status.Error(codes.Unavailable, "connection refused")
+}
+
+func TestCS3ErrorWhileGatewayIsDown(t *testing.T) {
+	info := testFileInfo()
+	policy := resilience.NewPolicy(resilience.Options{RetryAttempts: 1, BreakerThreshold: 1, BreakerTimeout: time.Minute})
+	app := newTestApp(t, resilience.NewGatewayClient(&unavailableGateway{}, policy))
+	fileURL := wopiURL(t, app, newTestWopiContext(t, info), "")
+
+	// the failure opens the circuit breaker
+	res := httptest.NewRecorder()
+	app.router().ServeHTTP(res, httptest.NewRequest(http.MethodGet, fileURL, nil))
+	if res.Code != http.StatusInternalServerError {
+		t.Fatalf("CheckFileInfo with a failing gateway = %d, want 500", res.Code)
+	}
+
+	// the WOPI client is told to try again later
+	res = httptest.NewRecorder()
+	app.router().ServeHTTP(res, httptest.NewRequest(http.MethodGet, fileURL, nil))
+	if res.Code != http.StatusServiceUnavailable {
+		t.Fatalf("CheckFileInfo while the circuit breaker is open = %d, want 503", res.Code)
+	}
+}
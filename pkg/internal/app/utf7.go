@@ -0,0 +1,95 @@
+package app
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"unicode/utf16"
+)
+
+// WOPI clients send and expect file names in headers UTF-7 encoded (RFC 2152)
+// https://docs.microsoft.com/en-us/microsoft-365/cloud-storage-partner-program/rest/files/putrelativefile
+
+// decodeUTF7 decodes an UTF-7 encoded string
+func decodeUTF7(s string) (string, error) {
+	var b strings.Builder
+
+	for i := 0; i < len(s); i++ {
+		if s[i] != '+' {
+			b.WriteByte(s[i])
+			continue
+		}
+
+		// find the end of the base64 encoded section
+		j := i + 1
+		for j < len(s) && isUTF7Base64Char(s[j]) {
+			j++
+		}
+
+		if j == i+1 {
+			// "+-" is an encoded "+"
+			b.WriteByte('+')
+		} else {
+			data, err := base64.RawStdEncoding.DecodeString(s[i+1 : j])
+			if err != nil {
+				return "", err
+			}
+			if len(data)%2 != 0 {
+				return "", errors.New("invalid UTF-7 encoded string")
+			}
+
+			u16 := make([]uint16, 0, len(data)/2)
+			for k := 0; k < len(data); k += 2 {
+				u16 = append(u16, uint16(data[k])<<8|uint16(data[k+1]))
+			}
+			b.WriteString(string(utf16.Decode(u16)))
+		}
+
+		// the "-" terminating a base64 encoded section is absorbed
+		i = j
+		if j < len(s) && s[j] != '-' {
+			i = j - 1
+		}
+	}
+
+	return b.String(), nil
+}
+
+// encodeUTF7 encodes a string with UTF-7
+func encodeUTF7(s string) string {
+	var b strings.Builder
+
+	runes := []rune(s)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case r == '+':
+			b.WriteString("+-")
+		case r >= 0x20 && r <= 0x7e:
+			b.WriteRune(r)
+		default:
+			// encode all consecutive non printable ASCII characters in one base64 section
+			j := i
+			for j < len(runes) && (runes[j] < 0x20 || runes[j] > 0x7e) {
+				j++
+			}
+
+			u16 := utf16.Encode(runes[i:j])
+			data := make([]byte, 0, 2*len(u16))
+			for _, c := range u16 {
+				data = append(data, byte(c>>8), byte(c))
+			}
+
+			b.WriteByte('+')
+			b.WriteString(base64.RawStdEncoding.EncodeToString(data))
+			b.WriteByte('-')
+			i = j - 1
+		}
+	}
+
+	return b.String()
+}
+
+func isUTF7Base64Char(c byte) bool {
+	return c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '+' || c == '/'
+}
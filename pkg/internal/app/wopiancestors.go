@@ -0,0 +1,210 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+
+	rpcv1beta1 "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
+	providerv1beta1 "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+)
+
+// the maximum number of parents we resolve, protects against loops in the parent chain
+const maxAncestors int = 100
+
+type EnumerateAncestorsResponse struct {
+	// The ancestors of the file or container, the root container is the first one.
+	AncestorsWithRootFirst []ContainerPointer `json:"AncestorsWithRootFirst"`
+}
+
+// EnumerateAncestors returns the containers the file is located in
+// https://docs.microsoft.com/en-us/microsoft-365/cloud-storage-partner-program/rest/files/enumerateancestors
+func EnumerateAncestors(app *demoApp, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	wopiContext, _ := WopiContextFromCtx(ctx)
+
+	statRes, err := app.gwc.Stat(ctx, &providerv1beta1.StatRequest{
+		Ref: &wopiContext.FileReference,
+	})
+	if err != nil {
+		app.Logger.Error().Err(err).Str("FileReference", wopiContext.FileReference.String()).Msg("EnumerateAncestors: stat failed")
+		cs3Error(w, err)
+		return
+	}
+
+	switch statRes.Status.Code {
+	case rpcv1beta1.Code_CODE_OK:
+	case rpcv1beta1.Code_CODE_NOT_FOUND:
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	default:
+		app.Logger.Error().Str("status_code", statRes.Status.Code.String()).Str("status_msg", statRes.Status.Message).Str("FileReference", wopiContext.FileReference.String()).Msg("EnumerateAncestors: stat failed")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	enumerateAncestors(app, w, r, "EnumerateAncestors", statRes.Info)
+}
+
+// EnumerateContainerAncestors returns the containers the container is located in
+// https://docs.microsoft.com/en-us/microsoft-365/cloud-storage-partner-program/rest/containers/enumerateancestors
+func EnumerateContainerAncestors(app *demoApp, w http.ResponseWriter, r *http.Request) {
+	info, ok := statContainer(app, w, r, "EnumerateContainerAncestors")
+	if !ok {
+		return
+	}
+
+	enumerateAncestors(app, w, r, "EnumerateContainerAncestors", info)
+}
+
+// enumerateAncestors responds with the ancestors of the resource, root first
+func enumerateAncestors(app *demoApp, w http.ResponseWriter, r *http.Request, operation string, info *providerv1beta1.ResourceInfo) {
+	ctx := r.Context()
+	wopiContext, _ := WopiContextFromCtx(ctx)
+
+	ancestors, err := app.ancestors(ctx, info)
+	if err != nil {
+		app.Logger.Error().Err(err).Str("FileReference", wopiContext.FileReference.String()).Msg(operation + ": resolving the ancestors failed")
+		cs3Error(w, err)
+		return
+	}
+
+	response := EnumerateAncestorsResponse{
+		AncestorsWithRootFirst: make([]ContainerPointer, 0, len(ancestors)),
+	}
+	for _, ancestor := range ancestors {
+		containerURL, err := app.newContainerURL(wopiContext, ancestor)
+		if err != nil {
+			app.Logger.Error().Err(err).Str("FileReference", wopiContext.FileReference.String()).Msg(operation + ": creating the access token failed")
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		response.AncestorsWithRootFirst = append(response.AncestorsWithRootFirst, ContainerPointer{
+			Name: containerName(ancestor),
+			Url:  containerURL,
+		})
+	}
+
+	jsonResponse, err := json.Marshal(response)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
+
+// ancestors resolves the parent chain of the resource, the root is the first element.
+// The chain ends at the space root or at the first parent the user can't access, eg. for shared files.
+func (app *demoApp) ancestors(ctx context.Context, info *providerv1beta1.ResourceInfo) ([]*providerv1beta1.ResourceInfo, error) {
+	var ancestors []*providerv1beta1.ResourceInfo
+
+	current := info
+	for i := 0; i < maxAncestors; i++ {
+		parent, err := app.parentInfo(ctx, current)
+		if err != nil {
+			return nil, err
+		}
+		if parent == nil {
+			break
+		}
+		ancestors = append([]*providerv1beta1.ResourceInfo{parent}, ancestors...)
+		current = parent
+	}
+
+	return ancestors, nil
+}
+
+// parentInfo returns the parent container of the resource or nil if there is no parent
+// or the user can't access it
+func (app *demoApp) parentInfo(ctx context.Context, info *providerv1beta1.ResourceInfo) (*providerv1beta1.ResourceInfo, error) {
+	if info.ParentId == nil || info.ParentId.OpaqueId == "" {
+		return nil, nil
+	}
+
+	// the path of the parent tells us whether the resource is located in the space root
+	getPathRes, err := app.gwc.GetPath(ctx, &providerv1beta1.GetPathRequest{
+		ResourceId: info.ParentId,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	switch getPathRes.Status.Code {
+	case rpcv1beta1.Code_CODE_OK:
+	case rpcv1beta1.Code_CODE_NOT_FOUND, rpcv1beta1.Code_CODE_PERMISSION_DENIED:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("get path failed with status code %s: %s", getPathRes.Status.Code.String(), getPathRes.Status.Message)
+	}
+
+	statRes, err := app.gwc.Stat(ctx, &providerv1beta1.StatRequest{
+		Ref: &providerv1beta1.Reference{
+			ResourceId: info.ParentId,
+			Path:       ".",
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	switch statRes.Status.Code {
+	case rpcv1beta1.Code_CODE_OK:
+	case rpcv1beta1.Code_CODE_NOT_FOUND, rpcv1beta1.Code_CODE_PERMISSION_DENIED:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("stat failed with status code %s: %s", statRes.Status.Code.String(), statRes.Status.Message)
+	}
+
+	parent := statRes.Info
+	if isRootPath(getPathRes.Path) {
+		// the space root has no parent we could navigate to
+		parent.ParentId = nil
+	}
+
+	return parent, nil
+}
+
+// containerName returns the display name of a container, space roots are named after the space
+func containerName(info *providerv1beta1.ResourceInfo) string {
+	name := path.Base(info.Path)
+	if name != "." && name != "/" && name != "" {
+		return name
+	}
+	if info.Space != nil && info.Space.Name != "" {
+		return info.Space.Name
+	}
+	if info.Name != "" && info.Name != "." {
+		return info.Name
+	}
+	return "/"
+}
+
+// webFolderURL returns the ownCloud Web url of the folder or an empty string if the web url is not configured
+func (app *demoApp) webFolderURL(id *providerv1beta1.ResourceId) string {
+	if app.Config.Web.URL == "" || id == nil {
+		return ""
+	}
+
+	folderURL, err := url.Parse(app.Config.Web.URL)
+	if err != nil {
+		app.Logger.Error().Err(err).Msg("parsing the web url failed")
+		return ""
+	}
+	// private links are resolved by ownCloud Web to the folder
+	folderURL.Path = path.Join(folderURL.Path, "f", formatResourceID(id))
+
+	return folderURL.String()
+}
+
+// isRootPath checks if a path returned by GetPath points to a space root
+func isRootPath(p string) bool {
+	p = path.Clean("/" + p)
+	return p == "/"
+}
@@ -0,0 +1,570 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	appproviderv1beta1 "github.com/cs3org/go-cs3apis/cs3/app/provider/v1beta1"
+	rpcv1beta1 "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
+	providerv1beta1 "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+)
+
+// the base name of new files in containers, if only an extension is suggested
+const defaultChildFileName string = "New document"
+
+type ContainerPointer struct {
+	// The name of the container without a path.
+	Name string `json:"Name"`
+	// A URI of the form http://server/<...>/wopi/containers/(container_id)?access_token=(access token) for the container.
+	Url string `json:"Url"`
+}
+
+type ChildFile struct {
+	// The name of the file, including extension, without a path.
+	Name string `json:"Name"`
+	// A URI of the form http://server/<...>/wopi/files/(file_id)?access_token=(access token) for the file.
+	Url string `json:"Url"`
+	// The last time the file was modified, in ISO 8601 round-trip format.
+	LastModifiedTime string `json:"LastModifiedTime,omitempty"`
+	// The size of the file in bytes.
+	Size int64 `json:"Size"`
+	// The current version of the file, like in CheckFileInfo.
+	Version string `json:"Version,omitempty"`
+}
+
+type EnumerateChildrenResponse struct {
+	ChildContainers []ContainerPointer `json:"ChildContainers"`
+	ChildFiles      []ChildFile        `json:"ChildFiles"`
+}
+
+type CreateChildContainerResponse struct {
+	ContainerPointer ContainerPointer `json:"ContainerPointer"`
+	ContainerInfo    ContainerInfo    `json:"ContainerInfo"`
+}
+
+// CheckContainerInfo returns information about the requested container
+// https://docs.microsoft.com/en-us/microsoft-365/cloud-storage-partner-program/rest/containers/checkcontainerinfo
+func CheckContainerInfo(app *demoApp, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	wopiContext, _ := WopiContextFromCtx(ctx)
+
+	info, ok := statContainer(app, w, r, "CheckContainerInfo")
+	if !ok {
+		return
+	}
+
+	jsonResponse, err := json.Marshal(containerInfo(wopiContext, info))
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
+
+// EnumerateChildren returns the files and containers in the requested container
+// https://docs.microsoft.com/en-us/microsoft-365/cloud-storage-partner-program/rest/containers/enumeratechildren
+func EnumerateChildren(app *demoApp, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	wopiContext, _ := WopiContextFromCtx(ctx)
+
+	if _, ok := statContainer(app, w, r, "EnumerateChildren"); !ok {
+		return
+	}
+
+	// optional comma separated list of file extensions to return, eg. ".docx,.xlsx"
+	var extensionFilter []string
+	if filter := r.URL.Query().Get("file_extension_filter"); filter != "" {
+		extensionFilter = strings.Split(filter, ",")
+	}
+
+	listRes, err := app.gwc.ListContainer(ctx, &providerv1beta1.ListContainerRequest{
+		Ref: &wopiContext.FileReference,
+	})
+	if err != nil {
+		app.Logger.Error().Err(err).Str("FileReference", wopiContext.FileReference.String()).Msg("EnumerateChildren: list container failed")
+		cs3Error(w, err)
+		return
+	}
+
+	if listRes.Status.Code != rpcv1beta1.Code_CODE_OK {
+		app.Logger.Error().Str("status_code", listRes.Status.Code.String()).Str("status_msg", listRes.Status.Message).Str("FileReference", wopiContext.FileReference.String()).Msg("EnumerateChildren: list container failed")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	response := EnumerateChildrenResponse{
+		ChildContainers: []ContainerPointer{},
+		ChildFiles:      []ChildFile{},
+	}
+
+	for _, info := range listRes.Infos {
+		name := path.Base(info.Path)
+
+		switch info.Type {
+		case providerv1beta1.ResourceType_RESOURCE_TYPE_CONTAINER:
+			containerURL, err := app.newContainerURL(wopiContext, info)
+			if err != nil {
+				app.Logger.Error().Err(err).Str("FileReference", wopiContext.FileReference.String()).Str("child", name).Msg("EnumerateChildren: creating the access token failed")
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+
+			response.ChildContainers = append(response.ChildContainers, ContainerPointer{
+				Name: name,
+				Url:  containerURL,
+			})
+
+		case providerv1beta1.ResourceType_RESOURCE_TYPE_FILE:
+			if !matchesExtensionFilter(name, extensionFilter) {
+				continue
+			}
+
+			fileURL, _, _, err := app.newFileURLs(wopiContext, info, name)
+			if err != nil {
+				app.Logger.Error().Err(err).Str("FileReference", wopiContext.FileReference.String()).Str("child", name).Msg("EnumerateChildren: creating the access token failed")
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+
+			response.ChildFiles = append(response.ChildFiles, ChildFile{
+				Name:             name,
+				Url:              fileURL,
+				LastModifiedTime: lastModifiedTime(info.Mtime),
+				Size:             int64(info.Size),
+				Version:          itemVersion(info.Etag),
+			})
+		}
+	}
+
+	jsonResponse, err := json.Marshal(response)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
+
+// CreateChildFile creates a new file in the requested container
+// https://docs.microsoft.com/en-us/microsoft-365/cloud-storage-partner-program/rest/containers/createchildfile
+func CreateChildFile(app *demoApp, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	wopiContext, _ := WopiContextFromCtx(ctx)
+
+	defer r.Body.Close()
+
+	if wopiContext.ViewMode != appproviderv1beta1.OpenInAppRequest_VIEW_MODE_READ_WRITE {
+		notAuthorized(w)
+		return
+	}
+
+	info, ok := statContainer(app, w, r, "CreateChildFile")
+	if !ok {
+		return
+	}
+
+	createFileInContainer(app, w, r, "CreateChildFile", info.Id, defaultChildFileName, r.Body, r.ContentLength)
+}
+
+// CreateChildContainer creates a new container in the requested container
+// https://docs.microsoft.com/en-us/microsoft-365/cloud-storage-partner-program/rest/containers/createchildcontainer
+func CreateChildContainer(app *demoApp, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	wopiContext, _ := WopiContextFromCtx(ctx)
+
+	if wopiContext.ViewMode != appproviderv1beta1.OpenInAppRequest_VIEW_MODE_READ_WRITE {
+		notAuthorized(w)
+		return
+	}
+
+	suggestedTarget := r.Header.Get(HeaderWopiSuggestedTarget)
+	relativeTarget := r.Header.Get(HeaderWopiRelativeTarget)
+
+	if suggestedTarget != "" && relativeTarget != "" {
+		// the headers are mutually exclusive
+		http.Error(w, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
+		return
+	}
+	if suggestedTarget == "" && relativeTarget == "" {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	parentInfo, ok := statContainer(app, w, r, "CreateChildContainer")
+	if !ok {
+		return
+	}
+
+	var targetName string
+	if suggestedTarget != "" {
+		// the WOPI server may modify the suggested name to make it valid and unique
+		name, err := decodeUTF7(suggestedTarget)
+		if err != nil || !isValidFileName(name) {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+
+		targetName, err = availableFileName(ctx, app, parentInfo.Id, name)
+		if err != nil {
+			app.Logger.Error().Err(err).Str("FileReference", wopiContext.FileReference.String()).Str("target_name", name).Msg("CreateChildContainer: finding an available name failed")
+			cs3Error(w, err)
+			return
+		}
+	} else {
+		// the WOPI server must not modify the relative target name
+		name, err := decodeUTF7(relativeTarget)
+		if err != nil || !isValidFileName(name) {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		targetName = name
+	}
+
+	targetRef := &providerv1beta1.Reference{
+		ResourceId: parentInfo.Id,
+		Path:       relativePath(targetName),
+	}
+
+	createRes, err := app.gwc.CreateContainer(ctx, &providerv1beta1.CreateContainerRequest{
+		Ref: targetRef,
+	})
+	if err != nil {
+		app.Logger.Error().Err(err).Str("FileReference", targetRef.String()).Msg("CreateChildContainer: create container failed")
+		cs3Error(w, err)
+		return
+	}
+
+	switch createRes.Status.Code {
+	case rpcv1beta1.Code_CODE_OK:
+
+	case rpcv1beta1.Code_CODE_ALREADY_EXISTS:
+		validName, err := availableFileName(ctx, app, parentInfo.Id, targetName)
+		if err == nil {
+			w.Header().Set(HeaderWopiValidRelativeTarget, encodeUTF7(validName))
+		}
+		http.Error(w, http.StatusText(http.StatusConflict), http.StatusConflict)
+		return
+
+	case rpcv1beta1.Code_CODE_PERMISSION_DENIED:
+		app.Logger.Warn().Str("status_msg", createRes.Status.Message).Str("FileReference", targetRef.String()).Msg("CreateChildContainer: create container not permitted")
+		notAuthorized(w)
+		return
+
+	default:
+		app.Logger.Error().Str("status_code", createRes.Status.Code.String()).Str("status_msg", createRes.Status.Message).Str("FileReference", targetRef.String()).Msg("CreateChildContainer: create container failed")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	statRes, err := app.gwc.Stat(ctx, &providerv1beta1.StatRequest{
+		Ref: targetRef,
+	})
+	if err != nil {
+		app.Logger.Error().Err(err).Str("FileReference", targetRef.String()).Msg("CreateChildContainer: stat of the new container failed")
+		cs3Error(w, err)
+		return
+	}
+
+	if statRes.Status.Code != rpcv1beta1.Code_CODE_OK {
+		app.Logger.Error().Str("status_code", statRes.Status.Code.String()).Str("status_msg", statRes.Status.Message).Str("FileReference", targetRef.String()).Msg("CreateChildContainer: stat of the new container failed")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	containerURL, err := app.newContainerURL(wopiContext, statRes.Info)
+	if err != nil {
+		app.Logger.Error().Err(err).Str("FileReference", targetRef.String()).Msg("CreateChildContainer: creating the access token failed")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	jsonResponse, err := json.Marshal(CreateChildContainerResponse{
+		ContainerPointer: ContainerPointer{
+			Name: targetName,
+			Url:  containerURL,
+		},
+		ContainerInfo: containerInfo(wopiContext, statRes.Info),
+	})
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
+
+// DeleteContainer deletes the requested container, only empty containers can be deleted
+// https://docs.microsoft.com/en-us/microsoft-365/cloud-storage-partner-program/rest/containers/deletecontainer
+func DeleteContainer(app *demoApp, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	wopiContext, _ := WopiContextFromCtx(ctx)
+
+	if wopiContext.ViewMode != appproviderv1beta1.OpenInAppRequest_VIEW_MODE_READ_WRITE {
+		notAuthorized(w)
+		return
+	}
+
+	info, ok := statContainer(app, w, r, "DeleteContainer")
+	if !ok {
+		return
+	}
+
+	if info.ParentId == nil {
+		// the root of a space can't be deleted
+		http.Error(w, http.StatusText(http.StatusConflict), http.StatusConflict)
+		return
+	}
+
+	listRes, err := app.gwc.ListContainer(ctx, &providerv1beta1.ListContainerRequest{
+		Ref: &wopiContext.FileReference,
+	})
+	if err != nil {
+		app.Logger.Error().Err(err).Str("FileReference", wopiContext.FileReference.String()).Msg("DeleteContainer: list container failed")
+		cs3Error(w, err)
+		return
+	}
+
+	if listRes.Status.Code != rpcv1beta1.Code_CODE_OK {
+		app.Logger.Error().Str("status_code", listRes.Status.Code.String()).Str("status_msg", listRes.Status.Message).Str("FileReference", wopiContext.FileReference.String()).Msg("DeleteContainer: list container failed")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	if len(listRes.Infos) > 0 {
+		http.Error(w, http.StatusText(http.StatusConflict), http.StatusConflict)
+		return
+	}
+
+	deleteRes, err := app.gwc.Delete(ctx, &providerv1beta1.DeleteRequest{
+		Ref: &wopiContext.FileReference,
+	})
+	if err != nil {
+		app.Logger.Error().Err(err).Str("FileReference", wopiContext.FileReference.String()).Msg("DeleteContainer: delete failed")
+		cs3Error(w, err)
+		return
+	}
+
+	switch deleteRes.Status.Code {
+	case rpcv1beta1.Code_CODE_OK:
+		http.Error(w, http.StatusText(http.StatusOK), http.StatusOK)
+		return
+
+	case rpcv1beta1.Code_CODE_NOT_FOUND:
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+
+	case rpcv1beta1.Code_CODE_PERMISSION_DENIED:
+		app.Logger.Warn().Str("status_msg", deleteRes.Status.Message).Str("FileReference", wopiContext.FileReference.String()).Msg("DeleteContainer: delete not permitted")
+		notAuthorized(w)
+		return
+
+	case rpcv1beta1.Code_CODE_LOCKED, rpcv1beta1.Code_CODE_ABORTED, rpcv1beta1.Code_CODE_FAILED_PRECONDITION:
+		http.Error(w, http.StatusText(http.StatusConflict), http.StatusConflict)
+		return
+
+	default:
+		app.Logger.Error().Str("status_code", deleteRes.Status.Code.String()).Str("status_msg", deleteRes.Status.Message).Str("FileReference", wopiContext.FileReference.String()).Msg("DeleteContainer: delete failed")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+}
+
+// RenameContainer renames the requested container within its parent
+// https://docs.microsoft.com/en-us/microsoft-365/cloud-storage-partner-program/rest/containers/renamecontainer
+func RenameContainer(app *demoApp, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	wopiContext, _ := WopiContextFromCtx(ctx)
+
+	if wopiContext.ViewMode != appproviderv1beta1.OpenInAppRequest_VIEW_MODE_READ_WRITE {
+		notAuthorized(w)
+		return
+	}
+
+	requestedName, err := decodeUTF7(r.Header.Get(HeaderWopiRequestedName))
+	if err != nil || !isValidFileName(requestedName) {
+		w.Header().Set(HeaderWopiInvalidContainerNameError, "Invalid container name")
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	info, ok := statContainer(app, w, r, "RenameContainer")
+	if !ok {
+		return
+	}
+
+	if info.ParentId == nil {
+		// the root of a space can't be renamed by moving it
+		http.Error(w, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
+		return
+	}
+
+	if requestedName == path.Base(info.Path) {
+		// nothing to rename
+		renameFileResponse(app, w, requestedName)
+		return
+	}
+
+	// the WOPI server may modify the requested name if the name is already taken
+	targetName, err := availableFileName(ctx, app, info.ParentId, requestedName)
+	if err != nil {
+		app.Logger.Error().Err(err).Str("FileReference", wopiContext.FileReference.String()).Str("target_name", requestedName).Msg("RenameContainer: finding an available name failed")
+		w.Header().Set(HeaderWopiInvalidContainerNameError, "Container name already taken")
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	moveRes, err := app.gwc.Move(ctx, &providerv1beta1.MoveRequest{
+		Source: &wopiContext.FileReference,
+		Destination: &providerv1beta1.Reference{
+			ResourceId: info.ParentId,
+			Path:       relativePath(targetName),
+		},
+	})
+	if err != nil {
+		app.Logger.Error().Err(err).Str("FileReference", wopiContext.FileReference.String()).Str("target_name", targetName).Msg("RenameContainer: move failed")
+		cs3Error(w, err)
+		return
+	}
+
+	switch moveRes.Status.Code {
+	case rpcv1beta1.Code_CODE_OK:
+		// the response has the same format as the RenameFile response
+		renameFileResponse(app, w, targetName)
+		return
+
+	case rpcv1beta1.Code_CODE_NOT_FOUND:
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+
+	case rpcv1beta1.Code_CODE_PERMISSION_DENIED:
+		app.Logger.Warn().Str("status_msg", moveRes.Status.Message).Str("FileReference", wopiContext.FileReference.String()).Msg("RenameContainer: move not permitted")
+		notAuthorized(w)
+		return
+
+	case rpcv1beta1.Code_CODE_ALREADY_EXISTS:
+		w.Header().Set(HeaderWopiInvalidContainerNameError, "Container name already taken")
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+
+	case rpcv1beta1.Code_CODE_LOCKED, rpcv1beta1.Code_CODE_ABORTED, rpcv1beta1.Code_CODE_FAILED_PRECONDITION:
+		http.Error(w, http.StatusText(http.StatusConflict), http.StatusConflict)
+		return
+
+	default:
+		app.Logger.Error().Str("status_code", moveRes.Status.Code.String()).Str("status_msg", moveRes.Status.Message).Str("FileReference", wopiContext.FileReference.String()).Str("target_name", targetName).Msg("RenameContainer: move failed")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+}
+
+// statContainer stats the container of the wopi context and responds with an error if it
+// doesn't exist or isn't a container
+func statContainer(app *demoApp, w http.ResponseWriter, r *http.Request, operation string) (*providerv1beta1.ResourceInfo, bool) {
+	ctx := r.Context()
+	wopiContext, _ := WopiContextFromCtx(ctx)
+
+	statRes, err := app.gwc.Stat(ctx, &providerv1beta1.StatRequest{
+		Ref: &wopiContext.FileReference,
+	})
+	if err != nil {
+		app.Logger.Error().Err(err).Str("FileReference", wopiContext.FileReference.String()).Msg(operation + ": stat failed")
+		cs3Error(w, err)
+		return nil, false
+	}
+
+	switch statRes.Status.Code {
+	case rpcv1beta1.Code_CODE_OK:
+	case rpcv1beta1.Code_CODE_NOT_FOUND:
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return nil, false
+	default:
+		app.Logger.Error().Str("status_code", statRes.Status.Code.String()).Str("status_msg", statRes.Status.Message).Str("FileReference", wopiContext.FileReference.String()).Msg(operation + ": stat failed")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return nil, false
+	}
+
+	if statRes.Info.Type != providerv1beta1.ResourceType_RESOURCE_TYPE_CONTAINER {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return nil, false
+	}
+
+	return statRes.Info, true
+}
+
+// containerInfo returns the CheckContainerInfo properties of a container
+func containerInfo(wopiContext WopiContext, info *providerv1beta1.ResourceInfo) ContainerInfo {
+	containerInfo := ContainerInfo{
+		Name: containerName(info),
+	}
+
+	if wopiContext.ViewMode == appproviderv1beta1.OpenInAppRequest_VIEW_MODE_READ_WRITE {
+		containerInfo.UserCanCreateChildContainer = info.PermissionSet.GetCreateContainer()
+		containerInfo.UserCanCreateChildFile = info.PermissionSet.GetInitiateFileUpload()
+		containerInfo.UserCanDelete = info.PermissionSet.GetDelete() && info.ParentId != nil
+		containerInfo.UserCanRename = info.PermissionSet.GetMove() && info.ParentId != nil
+	}
+
+	return containerInfo
+}
+
+// newContainerURL mints an access token for a container and returns the WOPI url of the container
+// including the access token. The access token inherits the user of the wopi context, the view mode
+// is derived from the permissions on the container.
+func (app *demoApp) newContainerURL(wopiContext WopiContext, info *providerv1beta1.ResourceInfo) (string, error) {
+	canWrite := info.PermissionSet.GetCreateContainer() || info.PermissionSet.GetInitiateFileUpload() ||
+		info.PermissionSet.GetDelete() || info.PermissionSet.GetMove()
+
+	containerWopiContext := wopiContext
+	containerWopiContext.FileReference = providerv1beta1.Reference{
+		ResourceId: info.Id,
+		Path:       ".",
+	}
+	containerWopiContext.ViewMode = derivedViewMode(wopiContext, canWrite)
+	containerWopiContext.ViewAppUrl = ""
+	containerWopiContext.EditAppUrl = ""
+	containerWopiContext.VersionKey = ""
+	containerWopiContext.Scope = WopiContextScopeContainer
+
+	accessToken, _, err := app.newAccessToken(containerWopiContext)
+	if err != nil {
+		return "", err
+	}
+
+	containerURL := app.containerWopiSrcURL(fileRefFromResourceID(info.Id))
+	containerURL.RawQuery = url.Values{"access_token": []string{accessToken}}.Encode()
+
+	return containerURL.String(), nil
+}
+
+// containerWopiSrcURL returns the WOPI url for a container reference
+func (app *demoApp) containerWopiSrcURL(containerRef string) url.URL {
+	return url.URL{
+		Scheme: app.Config.HTTP.Scheme,
+		Host:   app.Config.HTTP.Addr,
+		Path:   path.Join("wopi", "containers", containerRef),
+	}
+}
+
+// matchesExtensionFilter checks if the file name has one of the extensions, an empty filter matches all files
+func matchesExtensionFilter(name string, extensions []string) bool {
+	if len(extensions) == 0 {
+		return true
+	}
+	ext := path.Ext(name)
+	for _, e := range extensions {
+		if strings.EqualFold(ext, strings.TrimSpace(e)) {
+			return true
+		}
+	}
+	return false
+}
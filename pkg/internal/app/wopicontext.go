@@ -20,6 +20,17 @@ const (
 	wopiContextKey key = iota
 )
 
+// WopiContextScope defines which kind of WOPI resource an access token can be used for
+type WopiContextScope int
+
+const (
+	WopiContextScopeFile WopiContextScope = iota
+	WopiContextScopeContainer
+	WopiContextScopeEcosystem
+	// WopiContextScopeVersions is used for the versions page, which is opened in the browser
+	WopiContextScopeVersions
+)
+
 type WopiContext struct {
 	AccessToken   string
 	FileReference providerv1beta1.Reference
@@ -27,9 +38,45 @@ type WopiContext struct {
 	ViewMode      appproviderv1beta1.OpenInAppRequest_ViewMode
 	EditAppUrl    string
 	ViewAppUrl    string
+	// VersionKey is the key of the file version the wopi context is scoped to, empty for the current version
+	VersionKey string
+	// Scope is the kind of WOPI resource the wopi context can be used for, files by default
+	Scope WopiContextScope
+	// Collabora are the Collabora options requested for the session, they are applied on top of the config
+	Collabora CollaboraSession
+}
+
+// CollaboraSession are Collabora options of a single WOPI session, eg. a watermark for a confidential file.
+// They can only restrict the features enabled by the config, the watermark text is added to the configured one.
+type CollaboraSession struct {
+	HidePrintOption         bool   `json:",omitempty"`
+	HideSaveOption          bool   `json:",omitempty"`
+	HideExportOption        bool   `json:",omitempty"`
+	WatermarkText           string `json:",omitempty"`
+	DisableInactiveMessages bool   `json:",omitempty"`
+	SaveAsPostmessage       bool   `json:",omitempty"`
 }
 
 func WopiContextAuthMiddleware(app *demoApp, next http.Handler) http.Handler {
+	return wopiContextAuthMiddleware(app, next, WopiContextScopeFile)
+}
+
+// WopiContainerContextAuthMiddleware is the WopiContextAuthMiddleware for access tokens scoped to a container
+func WopiContainerContextAuthMiddleware(app *demoApp, next http.Handler) http.Handler {
+	return wopiContextAuthMiddleware(app, next, WopiContextScopeContainer)
+}
+
+// WopiEcosystemContextAuthMiddleware is the WopiContextAuthMiddleware for access tokens scoped to the ecosystem
+func WopiEcosystemContextAuthMiddleware(app *demoApp, next http.Handler) http.Handler {
+	return wopiContextAuthMiddleware(app, next, WopiContextScopeEcosystem)
+}
+
+// WopiVersionsContextAuthMiddleware is the WopiContextAuthMiddleware for access tokens scoped to the versions page
+func WopiVersionsContextAuthMiddleware(app *demoApp, next http.Handler) http.Handler {
+	return wopiContextAuthMiddleware(app, next, WopiContextScopeVersions)
+}
+
+func wopiContextAuthMiddleware(app *demoApp, next http.Handler, scope WopiContextScope) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		accessToken := r.URL.Query().Get("access_token")
 		if accessToken == "" {
@@ -57,6 +104,12 @@ func WopiContextAuthMiddleware(app *demoApp, next http.Handler) http.Handler {
 			return
 		}
 
+		if claims.WopiContext.Scope != scope {
+			// eg. file access tokens must not be used for containers and vice versa
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
 		ctx := r.Context()
 
 		wopiContextAccessToken, err := DecryptAES([]byte(app.Config.WopiSecret), claims.WopiContext.AccessToken)
@@ -80,3 +133,12 @@ func WopiContextFromCtx(ctx context.Context) (WopiContext, error) {
 	}
 	return WopiContext{}, errors.New("no wopi context found")
 }
+
+// derivedViewMode returns the view mode of an access token that is derived from the wopi context for another
+// resource. The view mode is never raised, READ_WRITE is only kept if the user can write the other resource.
+func derivedViewMode(wopiContext WopiContext, canWrite bool) appproviderv1beta1.OpenInAppRequest_ViewMode {
+	if wopiContext.ViewMode == appproviderv1beta1.OpenInAppRequest_VIEW_MODE_READ_WRITE && !canWrite {
+		return appproviderv1beta1.OpenInAppRequest_VIEW_MODE_READ_ONLY
+	}
+	return wopiContext.ViewMode
+}
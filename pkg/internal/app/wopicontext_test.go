@@ -0,0 +1,29 @@
+package app
+
+import (
+	"testing"
+
+	appproviderv1beta1 "github.com/cs3org/go-cs3apis/cs3/app/provider/v1beta1"
+)
+
+func TestDerivedViewMode(t *testing.T) {
+	tests := []struct {
+		viewMode appproviderv1beta1.OpenInAppRequest_ViewMode
+		canWrite bool
+		want     appproviderv1beta1.OpenInAppRequest_ViewMode
+	}{
+		{appproviderv1beta1.OpenInAppRequest_VIEW_MODE_READ_WRITE, true, appproviderv1beta1.OpenInAppRequest_VIEW_MODE_READ_WRITE},
+		// a sibling the user can't write must not get a read-write access token
+		{appproviderv1beta1.OpenInAppRequest_VIEW_MODE_READ_WRITE, false, appproviderv1beta1.OpenInAppRequest_VIEW_MODE_READ_ONLY},
+		// the view mode is never raised
+		{appproviderv1beta1.OpenInAppRequest_VIEW_MODE_READ_ONLY, true, appproviderv1beta1.OpenInAppRequest_VIEW_MODE_READ_ONLY},
+		{appproviderv1beta1.OpenInAppRequest_VIEW_MODE_VIEW_ONLY, true, appproviderv1beta1.OpenInAppRequest_VIEW_MODE_VIEW_ONLY},
+		{appproviderv1beta1.OpenInAppRequest_VIEW_MODE_VIEW_ONLY, false, appproviderv1beta1.OpenInAppRequest_VIEW_MODE_VIEW_ONLY},
+	}
+
+	for _, tt := range tests {
+		if got := derivedViewMode(WopiContext{ViewMode: tt.viewMode}, tt.canWrite); got != tt.want {
+			t.Errorf("derivedViewMode(%s, %v) = %s, want %s", tt.viewMode, tt.canWrite, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,302 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	rpcv1beta1 "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
+	providerv1beta1 "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+)
+
+// the maximum length of a resource id in GetFileWopiSrc requests
+const maxResourceIDLength int = 1024
+
+type CheckEcosystemResponse struct {
+	// A Boolean value that indicates that the host supports the container operations.
+	SupportsContainers bool `json:"SupportsContainers"`
+}
+
+type GetEcosystemResponse struct {
+	// A URI of the form http://server/<...>/wopi/ecosystem?access_token=(access token) for the ecosystem.
+	Url string `json:"Url"`
+}
+
+type GetRootContainerResponse struct {
+	ContainerPointer ContainerPointer `json:"ContainerPointer"`
+}
+
+type GetFileWopiSrcResponse struct {
+	// A URI of the form http://server/<...>/wopi/files/(file_id)?access_token=(access token) for the file.
+	Url string `json:"Url"`
+}
+
+// CheckEcosystem returns information about the capabilities of the ecosystem
+// https://docs.microsoft.com/en-us/microsoft-365/cloud-storage-partner-program/rest/ecosystem/checkecosystem
+func CheckEcosystem(app *demoApp, w http.ResponseWriter, r *http.Request) {
+	jsonResponse, err := json.Marshal(CheckEcosystemResponse{
+		SupportsContainers: true,
+	})
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
+
+// GetEcosystem returns the ecosystem url for the current file or container
+// https://docs.microsoft.com/en-us/microsoft-365/cloud-storage-partner-program/rest/files/getecosystem
+func GetEcosystem(app *demoApp, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	wopiContext, _ := WopiContextFromCtx(ctx)
+
+	ecosystemURL, err := app.newEcosystemURL(wopiContext)
+	if err != nil {
+		app.Logger.Error().Err(err).Str("FileReference", wopiContext.FileReference.String()).Msg("GetEcosystem: creating the access token failed")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	jsonResponse, err := json.Marshal(GetEcosystemResponse{
+		Url: ecosystemURL,
+	})
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
+
+// GetRootContainer returns the root container of the user, which is the personal space
+// https://docs.microsoft.com/en-us/microsoft-365/cloud-storage-partner-program/rest/ecosystem/getrootcontainer
+func GetRootContainer(app *demoApp, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	wopiContext, _ := WopiContextFromCtx(ctx)
+
+	if _, ok := userInfoID(wopiContext.User); !ok {
+		// anonymous users and public link users don't have a personal space
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	listRes, err := app.gwc.ListStorageSpaces(ctx, &providerv1beta1.ListStorageSpacesRequest{
+		Filters: []*providerv1beta1.ListStorageSpacesRequest_Filter{
+			{
+				Type: providerv1beta1.ListStorageSpacesRequest_Filter_TYPE_SPACE_TYPE,
+				Term: &providerv1beta1.ListStorageSpacesRequest_Filter_SpaceType{
+					SpaceType: "personal",
+				},
+			},
+			{
+				Type: providerv1beta1.ListStorageSpacesRequest_Filter_TYPE_OWNER,
+				Term: &providerv1beta1.ListStorageSpacesRequest_Filter_Owner{
+					Owner: wopiContext.User.GetId(),
+				},
+			},
+		},
+	})
+	if err != nil {
+		app.Logger.Error().Err(err).Msg("GetRootContainer: list storage spaces failed")
+		cs3Error(w, err)
+		return
+	}
+
+	if listRes.Status.Code != rpcv1beta1.Code_CODE_OK {
+		app.Logger.Error().Str("status_code", listRes.Status.Code.String()).Str("status_msg", listRes.Status.Message).Msg("GetRootContainer: list storage spaces failed")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	var personalSpace *providerv1beta1.StorageSpace
+	for _, space := range listRes.StorageSpaces {
+		if space.SpaceType == "personal" && space.Root != nil {
+			personalSpace = space
+			break
+		}
+	}
+	if personalSpace == nil {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	// the view mode of the access token is derived from the permissions on the root of the space
+	statRes, err := app.gwc.Stat(ctx, &providerv1beta1.StatRequest{
+		Ref: &providerv1beta1.Reference{
+			ResourceId: personalSpace.Root,
+			Path:       ".",
+		},
+	})
+	if err != nil {
+		app.Logger.Error().Err(err).Msg("GetRootContainer: stat of the space root failed")
+		cs3Error(w, err)
+		return
+	}
+
+	if statRes.Status.Code != rpcv1beta1.Code_CODE_OK {
+		app.Logger.Error().Str("status_code", statRes.Status.Code.String()).Str("status_msg", statRes.Status.Message).Msg("GetRootContainer: stat of the space root failed")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	containerURL, err := app.newContainerURL(wopiContext, statRes.Info)
+	if err != nil {
+		app.Logger.Error().Err(err).Msg("GetRootContainer: creating the access token failed")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	jsonResponse, err := json.Marshal(GetRootContainerResponse{
+		ContainerPointer: ContainerPointer{
+			Name: personalSpace.Name,
+			Url:  containerURL,
+		},
+	})
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
+
+// GetFileWopiSrc returns the WOPISrc of a file for the CS3 resource id in the request body
+// https://docs.microsoft.com/en-us/microsoft-365/cloud-storage-partner-program/rest/ecosystem/getfilewopisrc
+func GetFileWopiSrc(app *demoApp, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	wopiContext, _ := WopiContextFromCtx(ctx)
+
+	defer r.Body.Close()
+
+	// read one more byte than allowed to detect oversized resource ids
+	body, err := io.ReadAll(io.LimitReader(r.Body, int64(maxResourceIDLength)+1))
+	if err != nil {
+		app.Logger.Error().Err(err).Msg("GetFileWopiSrc: reading the body failed")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if len(body) > maxResourceIDLength {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	resourceID, err := parseResourceID(strings.TrimSpace(string(body)))
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	// the user needs to have access to the file
+	statRes, err := app.gwc.Stat(ctx, &providerv1beta1.StatRequest{
+		Ref: &providerv1beta1.Reference{
+			ResourceId: resourceID,
+			Path:       ".",
+		},
+	})
+	if err != nil {
+		app.Logger.Error().Err(err).Str("ResourceId", resourceID.String()).Msg("GetFileWopiSrc: stat failed")
+		cs3Error(w, err)
+		return
+	}
+
+	switch statRes.Status.Code {
+	case rpcv1beta1.Code_CODE_OK:
+	case rpcv1beta1.Code_CODE_NOT_FOUND, rpcv1beta1.Code_CODE_PERMISSION_DENIED:
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	default:
+		app.Logger.Error().Str("status_code", statRes.Status.Code.String()).Str("status_msg", statRes.Status.Message).Str("ResourceId", resourceID.String()).Msg("GetFileWopiSrc: stat failed")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	if statRes.Info.Type != providerv1beta1.ResourceType_RESOURCE_TYPE_FILE {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	fileURL, _, _, err := app.newFileURLs(wopiContext, statRes.Info, path.Base(statRes.Info.Path))
+	if err != nil {
+		app.Logger.Error().Err(err).Str("ResourceId", resourceID.String()).Msg("GetFileWopiSrc: creating the access token failed")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	jsonResponse, err := json.Marshal(GetFileWopiSrcResponse{
+		Url: fileURL,
+	})
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
+
+// newEcosystemURL mints an access token for the ecosystem and returns the WOPI url of the ecosystem
+// including the access token. The access token inherits the user of the wopi context. The ecosystem has no
+// permissions of its own, its view mode only caps the access tokens that are minted from it, which derive
+// their view mode from the permissions on their resources.
+func (app *demoApp) newEcosystemURL(wopiContext WopiContext) (string, error) {
+	ecosystemWopiContext := wopiContext
+	ecosystemWopiContext.FileReference = providerv1beta1.Reference{}
+	ecosystemWopiContext.ViewAppUrl = ""
+	ecosystemWopiContext.EditAppUrl = ""
+	ecosystemWopiContext.VersionKey = ""
+	ecosystemWopiContext.Scope = WopiContextScopeEcosystem
+
+	accessToken, _, err := app.newAccessToken(ecosystemWopiContext)
+	if err != nil {
+		return "", err
+	}
+
+	ecosystemURL := url.URL{
+		Scheme:   app.Config.HTTP.Scheme,
+		Host:     app.Config.HTTP.Addr,
+		Path:     path.Join("wopi", "ecosystem"),
+		RawQuery: url.Values{"access_token": []string{accessToken}}.Encode(),
+	}
+
+	return ecosystemURL.String(), nil
+}
+
+// parseResourceID parses a resource id in the "storageid$spaceid!opaqueid" format used by oCIS
+func parseResourceID(s string) (*providerv1beta1.ResourceId, error) {
+	storageSpace, opaqueID, ok := strings.Cut(s, "!")
+	if !ok || opaqueID == "" {
+		return nil, errors.New("invalid resource id")
+	}
+
+	storageID, spaceID, ok := strings.Cut(storageSpace, "$")
+	if !ok {
+		// the storage id is optional
+		storageID, spaceID = "", storageSpace
+	}
+	if spaceID == "" {
+		return nil, errors.New("invalid resource id")
+	}
+
+	return &providerv1beta1.ResourceId{
+		StorageId: storageID,
+		SpaceId:   spaceID,
+		OpaqueId:  opaqueID,
+	}, nil
+}
+
+// formatResourceID formats a resource id in the "storageid$spaceid!opaqueid" format used by oCIS
+func formatResourceID(id *providerv1beta1.ResourceId) string {
+	return id.GetStorageId() + "$" + id.GetSpaceId() + "!" + id.GetOpaqueId()
+}
@@ -1,44 +1,175 @@
 package app
 
 import (
+	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
+	"path"
+	"strconv"
+	"strings"
 
+	rpcv1beta1 "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
+	providerv1beta1 "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
 	"github.com/wkloucek/cs3-wopi-server/pkg/internal/helpers"
 )
 
+// the Collabora status code for files that were modified outside of the WOPI session
+const collaboraStatusDocumentChanged int = 1010
+
+type PutFileResponse struct {
+	// The last time the file was modified, Collabora sends it with the next PutFile request.
+	LastModifiedTime string `json:"LastModifiedTime,omitempty"`
+}
+
+type collaboraConflictResponse struct {
+	COOLStatusCode int `json:"COOLStatusCode"`
+	LOOLStatusCode int `json:"LOOLStatusCode"`
+}
+
 // GetFile downloads the file from the storage
 // https://docs.microsoft.com/en-us/microsoft-365/cloud-storage-partner-program/rest/files/getfile
 func GetFile(app *demoApp, w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	wopiContext, _ := WopiContextFromCtx(ctx)
 
+	size, etag, name, ok := contentSize(app, w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set(HeaderWopiItemVersion, itemVersion(etag))
+
+	// empty files, eg. created by the "New document" dialog, are served as blank documents
+	var template []byte
+	if size == 0 && name != "" {
+		var err error
+		template, err = app.templateStore.Template(path.Ext(name))
+		if err != nil {
+			app.Logger.Warn().Err(err).Str("FileReference", wopiContext.FileReference.String()).Msg("GetFile: reading the template failed")
+		}
+		size = uint64(len(template))
+	}
+
+	// WOPI clients can't handle files bigger than the expected size
+	if maxExpectedSize := r.Header.Get(HeaderWopiMaxExpectedSize); maxExpectedSize != "" {
+		maxSize, err := strconv.ParseUint(maxExpectedSize, 10, 64)
+		if err == nil && size > maxSize {
+			app.Logger.Debug().Uint64("size", size).Uint64("max_expected_size", maxSize).Str("FileReference", wopiContext.FileReference.String()).Msg("GetFile: file is bigger than the expected size")
+			http.Error(w, http.StatusText(http.StatusPreconditionFailed), http.StatusPreconditionFailed)
+			return
+		}
+	}
+
+	if template != nil {
+		writeTemplate(w, r, name, template)
+		return
+	}
+
 	// download the file
 	resp, err := helpers.DownloadFile(
 		ctx,
-		&wopiContext.FileReference,
+		contentReference(wopiContext),
 		app.gwc,
 		wopiContext.AccessToken,
-		app.Config.CS3DataGatewayInsecure,
+		r.Header.Get("Range"),
+		app.dataGatewayClient,
 		app.Logger,
 	)
-
-	if err != nil || resp.StatusCode != http.StatusOK {
-		app.Logger.Error().Err(err).Str("status_code", http.StatusText(resp.StatusCode)).Str("FileReference", wopiContext.FileReference.String()).Msg("GetFile: downloading the file failed")
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	if err != nil {
+		app.Logger.Error().Err(err).Str("FileReference", wopiContext.FileReference.String()).Msg("GetFile: downloading the file failed")
+		cs3Error(w, err)
 		return
 	}
 
 	// read the file from the body
 	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusPartialContent:
+	case http.StatusRequestedRangeNotSatisfiable:
+		w.Header().Set("Content-Range", resp.Header.Get("Content-Range"))
+		http.Error(w, http.StatusText(http.StatusRequestedRangeNotSatisfiable), http.StatusRequestedRangeNotSatisfiable)
+		return
+	default:
+		app.Logger.Error().Str("status_code", http.StatusText(resp.StatusCode)).Str("FileReference", wopiContext.FileReference.String()).Msg("GetFile: downloading the file failed")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	// pass the content headers of the data gateway through
+	for _, header := range []string{"Content-Length", "Content-Range", "Content-Type", "Accept-Ranges", "ETag", "Last-Modified"} {
+		if value := resp.Header.Get(header); value != "" {
+			w.Header().Set(header, value)
+		}
+	}
+	if w.Header().Get("Content-Length") == "" && resp.StatusCode == http.StatusOK {
+		w.Header().Set("Content-Length", strconv.FormatUint(size, 10))
+	}
+	if w.Header().Get("ETag") == "" && etag != "" {
+		w.Header().Set("ETag", quoteETag(etag))
+	}
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "application/octet-stream")
+	}
+
+	w.WriteHeader(resp.StatusCode)
 	_, err = io.Copy(w, resp.Body)
 	if err != nil {
-		app.Logger.Error().Str("FileReference", wopiContext.FileReference.String()).Msg("GetFile: copying the file content to the response body failed")
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		// the status code is already sent, we can only log the error
+		app.Logger.Error().Err(err).Str("FileReference", wopiContext.FileReference.String()).Msg("GetFile: copying the file content to the response body failed")
 		return
 	}
+}
+
+// contentSize returns the size and etag of the content of the wopi context, which is either the
+// current file or one of its versions, and responds with an error if it can't be determined.
+// The file name is only returned for the current file.
+func contentSize(app *demoApp, w http.ResponseWriter, r *http.Request) (uint64, string, string, bool) {
+	ctx := r.Context()
+	wopiContext, _ := WopiContextFromCtx(ctx)
 
-	http.Error(w, "", http.StatusOK)
+	if wopiContext.VersionKey != "" {
+		version, err := app.fileVersion(ctx, &wopiContext.FileReference, wopiContext.VersionKey)
+		if err != nil {
+			app.Logger.Error().Err(err).Str("version_key", wopiContext.VersionKey).Str("FileReference", wopiContext.FileReference.String()).Msg("GetFile: getting the file version failed")
+			cs3Error(w, err)
+			return 0, "", "", false
+		}
+		if version == nil {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return 0, "", "", false
+		}
+		return version.Size, fileVersionItemVersion(version), "", true
+	}
+
+	statRes, err := app.gwc.Stat(ctx, &providerv1beta1.StatRequest{
+		Ref: &wopiContext.FileReference,
+	})
+	if err != nil {
+		app.Logger.Error().Err(err).Str("FileReference", wopiContext.FileReference.String()).Msg("GetFile: stat failed")
+		cs3Error(w, err)
+		return 0, "", "", false
+	}
+
+	switch statRes.Status.Code {
+	case rpcv1beta1.Code_CODE_OK:
+		return statRes.Info.Size, statRes.Info.Etag, path.Base(statRes.Info.Path), true
+	case rpcv1beta1.Code_CODE_NOT_FOUND:
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return 0, "", "", false
+	default:
+		app.Logger.Error().Str("status_code", statRes.Status.Code.String()).Str("status_msg", statRes.Status.Message).Str("FileReference", wopiContext.FileReference.String()).Msg("GetFile: stat failed")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return 0, "", "", false
+	}
+}
+
+// quoteETag returns the etag as quoted string, CS3 etags may or may not be quoted
+func quoteETag(etag string) string {
+	if strings.HasPrefix(etag, "\"") || strings.HasPrefix(etag, "W/\"") {
+		return etag
+	}
+	return "\"" + etag + "\""
 }
 
 // PutFile uploads the file to the storage
@@ -50,23 +181,186 @@ func PutFile(app *demoApp, w http.ResponseWriter, r *http.Request) {
 	// read the file from the body
 	defer r.Body.Close()
 
+	lockID := r.Header.Get(HeaderWopiLock)
+
+	// check the lock state before uploading the new content
+	lock, err := app.lockStore.GetLock(ctx, &wopiContext.FileReference)
+	if err != nil {
+		app.Logger.Error().Err(err).Str("lock_id", lockID).Str("FileReference", wopiContext.FileReference.String()).Msg("PutFile: GetLock failed")
+		cs3Error(w, err)
+		return
+	}
+
+	// the lock id that is sent to the storage along with the upload
+	storageLockID := ""
+
+	if lock == nil {
+		// an unlocked file may only be written if it is zero bytes, eg. a newly created file
+		statRes, err := app.gwc.Stat(ctx, &providerv1beta1.StatRequest{
+			Ref: &wopiContext.FileReference,
+		})
+		if err != nil {
+			app.Logger.Error().Err(err).Str("lock_id", lockID).Str("FileReference", wopiContext.FileReference.String()).Msg("PutFile: stat failed")
+			cs3Error(w, err)
+			return
+		}
+
+		if statRes.Status.Code != rpcv1beta1.Code_CODE_OK {
+			app.Logger.Error().Str("status_code", statRes.Status.Code.String()).Str("status_msg", statRes.Status.Message).Str("lock_id", lockID).Str("FileReference", wopiContext.FileReference.String()).Msg("PutFile: stat failed")
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		if statRes.Info.Size != 0 {
+			app.Logger.Warn().Str("lock_id", lockID).Str("FileReference", wopiContext.FileReference.String()).Msg("PutFile: file is not locked and not empty")
+			lockConflict(w, nil, "File not locked and not empty")
+			return
+		}
+	} else {
+		if !app.isWopiLock(lock) {
+			app.Logger.Warn().Str("lock_id", lockID).Str("lock_app_name", lock.AppName).Str("FileReference", wopiContext.FileReference.String()).Msg("PutFile: file is locked by another app")
+			lockConflict(w, lock, "File locked by another app")
+			return
+		}
+
+		if lock.LockId != lockID {
+			app.Logger.Warn().Str("lock_id", lockID).Str("current_lock_id", lock.LockId).Str("FileReference", wopiContext.FileReference.String()).Msg("PutFile: lock mismatch")
+			lockConflict(w, lock, "Lock mismatch")
+			return
+		}
+
+		storageLockID = app.lockStore.StorageLockID(ctx, &wopiContext.FileReference, lockID)
+	}
+
+	// files modified outside of the WOPI session, eg. by a sync client, must not be overwritten silently,
+	// unless the user decided to overwrite them. The etag of the session is stored with its lock.
+	expectedETag := ""
+	if lock != nil && !collaboraOverwrite(r) {
+		expectedETag = lockETag(lock)
+	}
+	if timestamp := collaboraTimestamp(r); timestamp != "" || expectedETag != "" {
+		statRes, err := app.gwc.Stat(ctx, &providerv1beta1.StatRequest{
+			Ref: &wopiContext.FileReference,
+		})
+		if err != nil {
+			app.Logger.Error().Err(err).Str("lock_id", lockID).Str("FileReference", wopiContext.FileReference.String()).Msg("PutFile: stat failed")
+			cs3Error(w, err)
+			return
+		}
+
+		if statRes.Status.Code != rpcv1beta1.Code_CODE_OK {
+			app.Logger.Error().Str("status_code", statRes.Status.Code.String()).Str("status_msg", statRes.Status.Message).Str("lock_id", lockID).Str("FileReference", wopiContext.FileReference.String()).Msg("PutFile: stat failed")
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		if (timestamp != "" && timestamp != lastModifiedTime(statRes.Info.Mtime)) ||
+			(expectedETag != "" && expectedETag != statRes.Info.Etag) {
+			app.Logger.Warn().Str("expected_etag", expectedETag).Str("etag", statRes.Info.Etag).Str("FileReference", wopiContext.FileReference.String()).Msg("PutFile: file was modified outside of the WOPI session")
+			modifiedConflict(app, w, r, lock)
+			return
+		}
+	}
+
 	// upload the file
-	err := helpers.UploadFile(
+	err = helpers.UploadFile(
 		ctx,
 		r.Body,
+		r.ContentLength,
 		&wopiContext.FileReference,
 		app.gwc,
 		wopiContext.AccessToken,
-		r.Header.Get(HeaderWopiLock),
-		app.Config.CS3DataGatewayInsecure,
+		storageLockID,
+		expectedETag,
+		app.dataGatewayClient,
 		app.Logger,
 	)
 
+	if errors.Is(err, helpers.ErrPreconditionFailed) {
+		app.Logger.Warn().Str("expected_etag", expectedETag).Str("FileReference", wopiContext.FileReference.String()).Msg("PutFile: file was modified outside of the WOPI session")
+		modifiedConflict(app, w, r, lock)
+		return
+	}
+
 	if err != nil {
 		app.Logger.Error().Err(err).Str("FileReference", wopiContext.FileReference.String()).Msg("PutFile: uploading the file failed")
+		cs3Error(w, err)
+		return
+	}
+
+	// the new version of the file, the upload already succeeded, so we don't fail if it can't be determined
+	response := PutFileResponse{}
+	etag := ""
+	statRes, err := app.gwc.Stat(ctx, &providerv1beta1.StatRequest{
+		Ref: &wopiContext.FileReference,
+	})
+	if err != nil || statRes.Status.Code != rpcv1beta1.Code_CODE_OK {
+		app.Logger.Warn().Err(err).Str("FileReference", wopiContext.FileReference.String()).Msg("PutFile: stat after the upload failed")
+	} else {
+		etag = statRes.Info.Etag
+		w.Header().Set(HeaderWopiItemVersion, itemVersion(etag))
+		response.LastModifiedTime = lastModifiedTime(statRes.Info.Mtime)
+	}
+
+	if lock != nil {
+		// the WOPI session continues with the uploaded content, an unknown etag disables the check for this session
+		if err := app.lockStore.RefreshLock(ctx, &wopiContext.FileReference, lockWithETag(lock, etag), ""); err != nil {
+			app.Logger.Error().Err(err).Str("lock_id", lockID).Str("FileReference", wopiContext.FileReference.String()).Msg("PutFile: storing the etag with the lock failed")
+		}
+	}
+
+	jsonResponse, err := json.Marshal(response)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
+
+// modifiedConflict responds to uploads of files that were modified outside of the WOPI session.
+// The session keeps its etag, so that retries fail as well, until the user decides to overwrite the file.
+func modifiedConflict(app *demoApp, w http.ResponseWriter, r *http.Request, currentLock *providerv1beta1.Lock) {
+	if currentLock != nil {
+		w.Header().Set(HeaderWopiLock, currentLock.LockId)
+	}
+	w.Header().Set(HeaderWopiLockFailureReason, "File modified outside of the WOPI session")
+
+	if collaboraTimestamp(r) == "" {
+		http.Error(w, http.StatusText(http.StatusConflict), http.StatusConflict)
+		return
+	}
+
+	// Collabora asks the user how to resolve the conflict
+	jsonResponse, err := json.Marshal(collaboraConflictResponse{
+		COOLStatusCode: collaboraStatusDocumentChanged,
+		LOOLStatusCode: collaboraStatusDocumentChanged,
+	})
+	if err != nil {
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
 
-	http.Error(w, "", http.StatusOK)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusConflict)
+	w.Write(jsonResponse)
+}
+
+// collaboraTimestamp returns the LastModifiedTime Collabora expects the file to have on upload
+func collaboraTimestamp(r *http.Request) string {
+	if timestamp := r.Header.Get(HeaderCoolWopiTimestamp); timestamp != "" {
+		return timestamp
+	}
+	return r.Header.Get(HeaderLoolWopiTimestamp)
+}
+
+// collaboraOverwrite checks if the user decided in Collabora to overwrite a file that was modified outside of the
+// WOPI session. Collabora sends the upload again without the timestamp then, its other PutFile headers are kept.
+func collaboraOverwrite(r *http.Request) bool {
+	if collaboraTimestamp(r) != "" {
+		return false
+	}
+	return r.Header.Get(HeaderCoolWopiIsModifiedByUser) != "" || r.Header.Get(HeaderLoolWopiIsModifiedByUser) != ""
 }
@@ -0,0 +1,580 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	appproviderv1beta1 "github.com/cs3org/go-cs3apis/cs3/app/provider/v1beta1"
+	rpcv1beta1 "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
+	providerv1beta1 "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	"github.com/wkloucek/cs3-wopi-server/pkg/internal/helpers"
+)
+
+const (
+	// the number of alternative names that are tried if a file name is already taken
+	maxFileNameCollisions int = 100
+
+	// the maximum length of the UserInfo string, WOPI clients won't send longer strings
+	maxUserInfoLength int = 1024
+)
+
+var errNoAvailableFileName = errors.New("no available file name found")
+
+type PutRelativeFileResponse struct {
+	// The string name of the file, including extension, without a path.
+	Name string `json:"Name"`
+	// A string URI of the form http://server/<...>/wopi/files/(file_id)?access_token=(access token), of the newly created file on the host.
+	Url string `json:"Url"`
+	// A URI to a host page that loads the view WOPI action for the new file.
+	HostViewUrl string `json:"HostViewUrl,omitempty"`
+	// A URI to a host page that loads the edit WOPI action for the new file.
+	HostEditUrl string `json:"HostEditUrl,omitempty"`
+}
+
+type RenameFileResponse struct {
+	// The name of the renamed file without a path or file extension.
+	Name string `json:"Name"`
+}
+
+// PutRelativeFile creates a new file in the folder of the current file
+// https://docs.microsoft.com/en-us/microsoft-365/cloud-storage-partner-program/rest/files/putrelativefile
+func PutRelativeFile(app *demoApp, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	wopiContext, _ := WopiContextFromCtx(ctx)
+
+	// read the file from the body
+	defer r.Body.Close()
+
+	if wopiContext.ViewMode != appproviderv1beta1.OpenInAppRequest_VIEW_MODE_READ_WRITE {
+		notAuthorized(w)
+		return
+	}
+
+	statRes, err := app.gwc.Stat(ctx, &providerv1beta1.StatRequest{
+		Ref: &wopiContext.FileReference,
+	})
+	if err != nil {
+		app.Logger.Error().Err(err).Str("FileReference", wopiContext.FileReference.String()).Msg("PutRelativeFile: stat failed")
+		cs3Error(w, err)
+		return
+	}
+
+	if statRes.Status.Code != rpcv1beta1.Code_CODE_OK {
+		app.Logger.Error().Str("status_code", statRes.Status.Code.String()).Str("status_msg", statRes.Status.Message).Str("FileReference", wopiContext.FileReference.String()).Msg("PutRelativeFile: stat failed")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	parentID := statRes.Info.ParentId
+	if parentID == nil {
+		app.Logger.Error().Str("FileReference", wopiContext.FileReference.String()).Msg("PutRelativeFile: file has no parent")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	createFileInContainer(app, w, r, "PutRelativeFile", parentID, path.Base(statRes.Info.Path), r.Body, r.ContentLength)
+}
+
+// createFileInContainer creates a new file with the content in the container, the size of the content is -1 if
+// it is unknown. The file name is taken from the X-WOPI-SuggestedTarget or X-WOPI-RelativeTarget header,
+// baseName is used if only an extension is suggested.
+func createFileInContainer(app *demoApp, w http.ResponseWriter, r *http.Request, operation string, parentID *providerv1beta1.ResourceId, baseName string, content io.ReadCloser, size int64) {
+	ctx := r.Context()
+	wopiContext, _ := WopiContextFromCtx(ctx)
+
+	suggestedTarget := r.Header.Get(HeaderWopiSuggestedTarget)
+	relativeTarget := r.Header.Get(HeaderWopiRelativeTarget)
+
+	if suggestedTarget != "" && relativeTarget != "" {
+		// the headers are mutually exclusive
+		http.Error(w, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
+		return
+	}
+	if suggestedTarget == "" && relativeTarget == "" {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	var targetName string
+	if suggestedTarget != "" {
+		// the WOPI server may modify the suggested name to make it valid and unique
+		name, err := decodeUTF7(suggestedTarget)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+
+		if strings.HasPrefix(name, ".") {
+			// only an extension was suggested, so we use the name of the current file
+			name = strings.TrimSuffix(baseName, path.Ext(baseName)) + name
+		}
+
+		if !isValidFileName(name) {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+
+		targetName, err = availableFileName(ctx, app, parentID, name)
+		if err != nil {
+			app.Logger.Error().Err(err).Str("FileReference", wopiContext.FileReference.String()).Str("target_name", name).Msg(operation + ": finding an available file name failed")
+			cs3Error(w, err)
+			return
+		}
+	} else {
+		// the WOPI server must not modify the relative target name
+		name, err := decodeUTF7(relativeTarget)
+		if err != nil || !isValidFileName(name) {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+
+		targetRef := &providerv1beta1.Reference{
+			ResourceId: parentID,
+			Path:       relativePath(name),
+		}
+
+		targetStatRes, err := app.gwc.Stat(ctx, &providerv1beta1.StatRequest{
+			Ref: targetRef,
+		})
+		if err != nil {
+			app.Logger.Error().Err(err).Str("FileReference", targetRef.String()).Msg(operation + ": stat of the target failed")
+			cs3Error(w, err)
+			return
+		}
+
+		switch targetStatRes.Status.Code {
+		case rpcv1beta1.Code_CODE_NOT_FOUND:
+			// the target doesn't exist yet
+
+		case rpcv1beta1.Code_CODE_OK:
+			validName, err := availableFileName(ctx, app, parentID, name)
+			if err != nil {
+				app.Logger.Error().Err(err).Str("FileReference", wopiContext.FileReference.String()).Str("target_name", name).Msg(operation + ": finding an available file name failed")
+				cs3Error(w, err)
+				return
+			}
+			w.Header().Set(HeaderWopiValidRelativeTarget, encodeUTF7(validName))
+
+			// the locks are keyed on the resource id, the target needs to be looked up by the id of the stat
+			targetLock, err := app.lockStore.GetLock(ctx, &providerv1beta1.Reference{ResourceId: targetStatRes.Info.Id, Path: "."})
+			if err != nil {
+				app.Logger.Error().Err(err).Str("FileReference", targetRef.String()).Msg(operation + ": GetLock of the target failed")
+				cs3Error(w, err)
+				return
+			}
+
+			if targetLock != nil {
+				app.Logger.Warn().Str("current_lock_id", targetLock.LockId).Str("FileReference", targetRef.String()).Msg(operation + ": target is locked")
+				lockConflict(w, targetLock, "Target file locked")
+				return
+			}
+
+			if !strings.EqualFold(r.Header.Get(HeaderWopiOverwriteRelativeTarget), "true") {
+				app.Logger.Debug().Str("FileReference", targetRef.String()).Msg(operation + ": target already exists")
+				http.Error(w, http.StatusText(http.StatusConflict), http.StatusConflict)
+				return
+			}
+
+			// the target may be overwritten
+			w.Header().Del(HeaderWopiValidRelativeTarget)
+
+		default:
+			app.Logger.Error().Str("status_code", targetStatRes.Status.Code.String()).Str("status_msg", targetStatRes.Status.Message).Str("FileReference", targetRef.String()).Msg(operation + ": stat of the target failed")
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		targetName = name
+	}
+
+	targetRef := &providerv1beta1.Reference{
+		ResourceId: parentID,
+		Path:       relativePath(targetName),
+	}
+
+	// upload the file
+	err := helpers.UploadFile(
+		ctx,
+		content,
+		size,
+		targetRef,
+		app.gwc,
+		wopiContext.AccessToken,
+		"",
+		"",
+		app.dataGatewayClient,
+		app.Logger,
+	)
+	if err != nil {
+		app.Logger.Error().Err(err).Str("FileReference", targetRef.String()).Msg(operation + ": uploading the file failed")
+		cs3Error(w, err)
+		return
+	}
+
+	targetStatRes, err := app.gwc.Stat(ctx, &providerv1beta1.StatRequest{
+		Ref: targetRef,
+	})
+	if err != nil {
+		app.Logger.Error().Err(err).Str("FileReference", targetRef.String()).Msg(operation + ": stat of the new file failed")
+		cs3Error(w, err)
+		return
+	}
+
+	if targetStatRes.Status.Code != rpcv1beta1.Code_CODE_OK {
+		app.Logger.Error().Str("status_code", targetStatRes.Status.Code.String()).Str("status_msg", targetStatRes.Status.Message).Str("FileReference", targetRef.String()).Msg(operation + ": stat of the new file failed")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	// mint a new access token for the new file
+	wopiURL, viewAppURL, editAppURL, err := app.newFileURLs(wopiContext, targetStatRes.Info, targetName)
+	if err != nil {
+		app.Logger.Error().Err(err).Str("FileReference", targetRef.String()).Msg(operation + ": creating the access token failed")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	jsonResponse, err := json.Marshal(PutRelativeFileResponse{
+		Name:        targetName,
+		Url:         wopiURL,
+		HostViewUrl: viewAppURL,
+		HostEditUrl: editAppURL,
+	})
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
+
+// newFileURLs mints an access token for a file and returns the WOPI url of the file including the access token
+// and the view and edit app urls. The access token inherits the user of the wopi context, the view mode is
+// derived from the permissions on the file.
+func (app *demoApp) newFileURLs(wopiContext WopiContext, info *providerv1beta1.ResourceInfo, name string) (string, string, string, error) {
+	fileRef := fileRefFromResourceID(info.Id)
+
+	viewAppURL, editAppURL, err := app.appURLsForFile(path.Ext(name), fileRef)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	fileWopiContext := wopiContext
+	fileWopiContext.FileReference = providerv1beta1.Reference{
+		ResourceId: info.Id,
+		Path:       ".",
+	}
+	fileWopiContext.ViewMode = derivedViewMode(wopiContext, info.PermissionSet.GetInitiateFileUpload())
+	fileWopiContext.ViewAppUrl = viewAppURL
+	fileWopiContext.EditAppUrl = editAppURL
+	// the version a session is scoped to is not passed on to other resources
+	fileWopiContext.VersionKey = ""
+	fileWopiContext.Scope = WopiContextScopeFile
+
+	accessToken, _, err := app.newAccessToken(fileWopiContext)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	wopiSrcURL := app.wopiSrcURL(fileRef)
+	wopiSrcURL.RawQuery = url.Values{"access_token": []string{accessToken}}.Encode()
+
+	return wopiSrcURL.String(), viewAppURL, editAppURL, nil
+}
+
+// RenameFile renames the file within its folder, the extension of the file is kept
+// https://docs.microsoft.com/en-us/microsoft-365/cloud-storage-partner-program/rest/files/renamefile
+func RenameFile(app *demoApp, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	wopiContext, _ := WopiContextFromCtx(ctx)
+
+	if wopiContext.ViewMode != appproviderv1beta1.OpenInAppRequest_VIEW_MODE_READ_WRITE {
+		notAuthorized(w)
+		return
+	}
+
+	lockID := r.Header.Get(HeaderWopiLock)
+
+	requestedName, err := decodeUTF7(r.Header.Get(HeaderWopiRequestedName))
+	if err != nil || requestedName == "" {
+		w.Header().Set(HeaderWopiInvalidFileNameError, "Invalid file name")
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	statRes, err := app.gwc.Stat(ctx, &providerv1beta1.StatRequest{
+		Ref: &wopiContext.FileReference,
+	})
+	if err != nil {
+		app.Logger.Error().Err(err).Str("FileReference", wopiContext.FileReference.String()).Msg("RenameFile: stat failed")
+		cs3Error(w, err)
+		return
+	}
+
+	switch statRes.Status.Code {
+	case rpcv1beta1.Code_CODE_OK:
+	case rpcv1beta1.Code_CODE_NOT_FOUND, rpcv1beta1.Code_CODE_PERMISSION_DENIED:
+		notAuthorized(w)
+		return
+	default:
+		app.Logger.Error().Str("status_code", statRes.Status.Code.String()).Str("status_msg", statRes.Status.Message).Str("FileReference", wopiContext.FileReference.String()).Msg("RenameFile: stat failed")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	parentID := statRes.Info.ParentId
+	if parentID == nil {
+		app.Logger.Error().Str("FileReference", wopiContext.FileReference.String()).Msg("RenameFile: file has no parent")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	// the requested name doesn't contain the extension, we keep the original one
+	ext := path.Ext(path.Base(statRes.Info.Path))
+	name := requestedName + ext
+	if !isValidFileName(name) {
+		w.Header().Set(HeaderWopiInvalidFileNameError, "Invalid characters in the file name")
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	if name == path.Base(statRes.Info.Path) {
+		// nothing to rename
+		renameFileResponse(app, w, requestedName)
+		return
+	}
+
+	storageLockID, ok := checkWopiLock(app, w, r, "RenameFile", lockID)
+	if !ok {
+		return
+	}
+
+	// the WOPI server may modify the requested name if the name is already taken
+	targetName, err := availableFileName(ctx, app, parentID, name)
+	if errors.Is(err, errNoAvailableFileName) {
+		w.Header().Set(HeaderWopiInvalidFileNameError, "File name already taken")
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		app.Logger.Error().Err(err).Str("FileReference", wopiContext.FileReference.String()).Str("target_name", name).Msg("RenameFile: finding an available file name failed")
+		cs3Error(w, err)
+		return
+	}
+
+	moveRes, err := app.gwc.Move(ctx, &providerv1beta1.MoveRequest{
+		Source: &wopiContext.FileReference,
+		Destination: &providerv1beta1.Reference{
+			ResourceId: parentID,
+			Path:       relativePath(targetName),
+		},
+		LockId: storageLockID,
+	})
+	if err != nil {
+		app.Logger.Error().Err(err).Str("FileReference", wopiContext.FileReference.String()).Str("target_name", targetName).Msg("RenameFile: move failed")
+		cs3Error(w, err)
+		return
+	}
+
+	switch moveRes.Status.Code {
+	case rpcv1beta1.Code_CODE_OK:
+		renameFileResponse(app, w, strings.TrimSuffix(targetName, ext))
+		return
+
+	case rpcv1beta1.Code_CODE_NOT_FOUND:
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+
+	case rpcv1beta1.Code_CODE_PERMISSION_DENIED:
+		app.Logger.Warn().Str("status_msg", moveRes.Status.Message).Str("FileReference", wopiContext.FileReference.String()).Msg("RenameFile: move not permitted")
+		notAuthorized(w)
+		return
+
+	case rpcv1beta1.Code_CODE_ALREADY_EXISTS:
+		w.Header().Set(HeaderWopiInvalidFileNameError, "File name already taken")
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+
+	case rpcv1beta1.Code_CODE_LOCKED, rpcv1beta1.Code_CODE_ABORTED, rpcv1beta1.Code_CODE_FAILED_PRECONDITION:
+		respondLockConflict(app, w, r, "RenameFile", lockID, nil)
+		return
+
+	default:
+		app.Logger.Error().Str("status_code", moveRes.Status.Code.String()).Str("status_msg", moveRes.Status.Message).Str("FileReference", wopiContext.FileReference.String()).Str("target_name", targetName).Msg("RenameFile: move failed")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+}
+
+// renameFileResponse responds with the new name of the file, without extension
+func renameFileResponse(app *demoApp, w http.ResponseWriter, name string) {
+	jsonResponse, err := json.Marshal(RenameFileResponse{
+		Name: name,
+	})
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
+
+// DeleteFile deletes the file, locked files can't be deleted
+// https://docs.microsoft.com/en-us/microsoft-365/cloud-storage-partner-program/rest/files/deletefile
+func DeleteFile(app *demoApp, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	wopiContext, _ := WopiContextFromCtx(ctx)
+
+	if wopiContext.ViewMode != appproviderv1beta1.OpenInAppRequest_VIEW_MODE_READ_WRITE {
+		notAuthorized(w)
+		return
+	}
+
+	lock, err := app.lockStore.GetLock(ctx, &wopiContext.FileReference)
+	if err != nil {
+		app.Logger.Error().Err(err).Str("FileReference", wopiContext.FileReference.String()).Msg("DeleteFile: GetLock failed")
+		cs3Error(w, err)
+		return
+	}
+
+	if lock != nil {
+		app.Logger.Warn().Str("current_lock_id", lock.LockId).Str("FileReference", wopiContext.FileReference.String()).Msg("DeleteFile: file is locked")
+		lockConflict(w, lock, "File locked")
+		return
+	}
+
+	deleteRes, err := app.gwc.Delete(ctx, &providerv1beta1.DeleteRequest{
+		Ref: &wopiContext.FileReference,
+	})
+	if err != nil {
+		app.Logger.Error().Err(err).Str("FileReference", wopiContext.FileReference.String()).Msg("DeleteFile: delete failed")
+		cs3Error(w, err)
+		return
+	}
+
+	switch deleteRes.Status.Code {
+	case rpcv1beta1.Code_CODE_OK:
+		http.Error(w, http.StatusText(http.StatusOK), http.StatusOK)
+		return
+
+	case rpcv1beta1.Code_CODE_NOT_FOUND:
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+
+	case rpcv1beta1.Code_CODE_PERMISSION_DENIED:
+		app.Logger.Warn().Str("status_msg", deleteRes.Status.Message).Str("FileReference", wopiContext.FileReference.String()).Msg("DeleteFile: delete not permitted")
+		notAuthorized(w)
+		return
+
+	case rpcv1beta1.Code_CODE_LOCKED, rpcv1beta1.Code_CODE_ABORTED, rpcv1beta1.Code_CODE_FAILED_PRECONDITION:
+		// the file got locked in the meantime
+		storageLockConflict(app, w, r, "DeleteFile")
+		return
+
+	default:
+		app.Logger.Error().Str("status_code", deleteRes.Status.Code.String()).Str("status_msg", deleteRes.Status.Message).Str("FileReference", wopiContext.FileReference.String()).Msg("DeleteFile: delete failed")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+}
+
+// PutUserInfo stores the UserInfo of the current user for the file
+// https://docs.microsoft.com/en-us/microsoft-365/cloud-storage-partner-program/rest/files/putuserinfo
+func PutUserInfo(app *demoApp, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	wopiContext, _ := WopiContextFromCtx(ctx)
+
+	defer r.Body.Close()
+
+	userID, ok := userInfoID(wopiContext.User)
+	if !ok {
+		// there is no stable id to store the UserInfo for anonymous users
+		notAuthorized(w)
+		return
+	}
+
+	// read one more byte than allowed to detect oversized UserInfo
+	userInfo, err := io.ReadAll(io.LimitReader(r.Body, int64(maxUserInfoLength)+1))
+	if err != nil {
+		app.Logger.Error().Err(err).Str("FileReference", wopiContext.FileReference.String()).Msg("PutUserInfo: reading the body failed")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	if len(userInfo) > maxUserInfoLength {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	err = app.userInfoStore.SetUserInfo(ctx, userID, fileRefFromResourceID(wopiContext.FileReference.ResourceId), string(userInfo))
+	if err != nil {
+		app.Logger.Error().Err(err).Str("FileReference", wopiContext.FileReference.String()).Msg("PutUserInfo: SetUserInfo failed")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	http.Error(w, http.StatusText(http.StatusOK), http.StatusOK)
+}
+
+// availableFileName returns name or, if a file with this name already exists in the parent,
+// an alternative name like "name (1).ext"
+func availableFileName(ctx context.Context, app *demoApp, parentID *providerv1beta1.ResourceId, name string) (string, error) {
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+
+	candidate := name
+	for i := 1; i <= maxFileNameCollisions; i++ {
+		statRes, err := app.gwc.Stat(ctx, &providerv1beta1.StatRequest{
+			Ref: &providerv1beta1.Reference{
+				ResourceId: parentID,
+				Path:       relativePath(candidate),
+			},
+		})
+		if err != nil {
+			return "", err
+		}
+
+		switch statRes.Status.Code {
+		case rpcv1beta1.Code_CODE_NOT_FOUND:
+			return candidate, nil
+		case rpcv1beta1.Code_CODE_OK:
+			candidate = fmt.Sprintf("%s (%d)%s", base, i, ext)
+		default:
+			return "", fmt.Errorf("stat failed with status code %s: %s", statRes.Status.Code.String(), statRes.Status.Message)
+		}
+	}
+
+	return "", fmt.Errorf("%w: %q", errNoAvailableFileName, name)
+}
+
+// isValidFileName checks that a file name can be used within a folder
+func isValidFileName(name string) bool {
+	if name == "" || name == "." || name == ".." {
+		return false
+	}
+	if len(name) > 255 {
+		return false
+	}
+	for _, r := range name {
+		if r == '/' || r == '\\' || r < 0x20 {
+			return false
+		}
+	}
+	return true
+}
+
+// relativePath returns the path of a file name relative to the referenced container
+func relativePath(name string) string {
+	return "./" + name
+}
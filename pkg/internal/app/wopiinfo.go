@@ -2,14 +2,20 @@ package app
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"net/url"
 	"path"
+	"strings"
+	"time"
 
 	appproviderv1beta1 "github.com/cs3org/go-cs3apis/cs3/app/provider/v1beta1"
 	userv1beta1 "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
 	rpcv1beta1 "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
 	providerv1beta1 "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	typesv1beta1 "github.com/cs3org/go-cs3apis/cs3/types/v1beta1"
 	"github.com/google/uuid"
+	"github.com/wkloucek/cs3-wopi-server/pkg/internal/resilience"
 )
 
 func WopiInfoHandler(app *demoApp, w http.ResponseWriter, r *http.Request) {
@@ -27,7 +33,7 @@ func CheckFileInfo(app *demoApp, w http.ResponseWriter, r *http.Request) {
 	})
 	if err != nil {
 		app.Logger.Error().Err(err).Str("FileReference", wopiContext.FileReference.String()).Msg("CheckFileInfo: stat failed")
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		cs3Error(w, err)
 		return
 	}
 
@@ -37,14 +43,15 @@ func CheckFileInfo(app *demoApp, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// the SHA256 stays empty, CS3 checksums have no SHA-256 type
 	fileInfo := FileInfo{
 		OwnerID:           statRes.Info.Owner.OpaqueId + "@" + statRes.Info.Owner.Idp,
 		Size:              int64(statRes.Info.Size),
-		Version:           statRes.Info.Mtime.String(),
+		Version:           itemVersion(statRes.Info.Etag),
+		LastModifiedTime:  lastModifiedTime(statRes.Info.Mtime),
 		BaseFileName:      path.Base(statRes.Info.Path),
+		FileExtension:     path.Ext(statRes.Info.Path),
 		BreadcrumbDocName: path.Base(statRes.Info.Path),
-		// to get the folder we actually need to do a GetPath() request
-		//BreadcrumbFolderName: path.Dir(statRes.Info.Path),
 
 		UserCanNotWriteRelative: true,
 
@@ -55,14 +62,22 @@ func CheckFileInfo(app *demoApp, w http.ResponseWriter, r *http.Request) {
 
 		SupportsExtendedLockLength: true,
 
-		SupportsGetLock: true,
-		SupportsLocks:   true,
+		SupportsGetLock:    true,
+		SupportsLocks:      true,
+		SupportsContainers: true,
+
+		SupportsEcosystem:      true,
+		SupportsGetFileWopiSrc: true,
 	}
 
 	switch wopiContext.ViewMode {
 	case appproviderv1beta1.OpenInAppRequest_VIEW_MODE_READ_WRITE:
 		fileInfo.SupportsUpdate = true
 		fileInfo.UserCanWrite = true
+		fileInfo.UserCanNotWriteRelative = false
+		fileInfo.SupportsRename = statRes.Info.PermissionSet.GetMove()
+		fileInfo.UserCanRename = statRes.Info.PermissionSet.GetMove()
+		fileInfo.SupportsDeleteFile = statRes.Info.PermissionSet.GetDelete()
 
 	case appproviderv1beta1.OpenInAppRequest_VIEW_MODE_READ_ONLY:
 		// nothing special to do here for now
@@ -73,6 +88,26 @@ func CheckFileInfo(app *demoApp, w http.ResponseWriter, r *http.Request) {
 		fileInfo.DisablePrint = true
 	}
 
+	// files locked by non-WOPI lock owners, eg. WebDAV clients or the web UI, can only be opened read-only
+	lock, err := app.lockStore.GetLock(ctx, &wopiContext.FileReference)
+	if err != nil {
+		// the file may be locked by another app, so it is opened read-only
+		app.Logger.Error().Err(err).Str("FileReference", wopiContext.FileReference.String()).Msg("CheckFileInfo: GetLock failed")
+		fileInfo.TemporarilyNotWritable = true
+		fileInfo.UserCanWrite = false
+		fileInfo.UserCanRename = false
+	} else if lock != nil && !app.isWopiLock(lock) {
+		app.Logger.Debug().Str("current_lock_id", lock.LockId).Str("lock_app_name", lock.AppName).Str("FileReference", wopiContext.FileReference.String()).Msg("CheckFileInfo: file is locked by another app")
+		fileInfo.TemporarilyNotWritable = true
+		fileInfo.UserCanWrite = false
+		fileInfo.UserCanRename = false
+	}
+
+	// uploads fail fast while the data gateway is down, the WOPI client should not try to save
+	if !app.cs3Policy.Available(resilience.DataGatewayEndpoint) {
+		fileInfo.TemporarilyNotWritable = true
+	}
+
 	// user logic from reva wopi driver #TODO: refactor
 	var isPublicShare bool = false
 	if wopiContext.User != nil {
@@ -99,6 +134,73 @@ func CheckFileInfo(app *demoApp, w http.ResponseWriter, r *http.Request) {
 		fileInfo.IsAnonymousUser = true
 	}
 
+	fileInfo.SupportedShareUrlTypes = app.supportedShareUrlTypes(wopiContext, statRes.Info)
+
+	if wopiContext.VersionKey != "" {
+		// a previous version of the file is opened read-only
+		version, err := app.fileVersion(ctx, &wopiContext.FileReference, wopiContext.VersionKey)
+		if err != nil {
+			app.Logger.Error().Err(err).Str("version_key", wopiContext.VersionKey).Str("FileReference", wopiContext.FileReference.String()).Msg("CheckFileInfo: getting the file version failed")
+			cs3Error(w, err)
+			return
+		}
+		if version == nil {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+
+		fileInfo.Size = int64(version.Size)
+		fileInfo.Version = fileVersionItemVersion(version)
+		fileInfo.LastModifiedTime = lastModifiedTime(&typesv1beta1.Timestamp{Seconds: version.Mtime})
+		fileInfo.BreadcrumbDocName = fmt.Sprintf("%s (%s)", fileInfo.BaseFileName, time.Unix(int64(version.Mtime), 0).UTC().Format(time.RFC1123))
+		fileInfo.ReadOnly = true
+		fileInfo.UserCanWrite = false
+		fileInfo.SupportsUpdate = false
+		fileInfo.UserCanNotWriteRelative = true
+		fileInfo.UserCanRename = false
+		fileInfo.SupportsRename = false
+		fileInfo.SupportsDeleteFile = false
+		fileInfo.SupportedShareUrlTypes = nil
+		fileInfo.HostEditUrl = ""
+	} else if statRes.Info.PermissionSet.GetListFileVersions() {
+		fileVersionURL, err := app.fileVersionsURL(wopiContext)
+		if err != nil {
+			app.Logger.Error().Err(err).Str("FileReference", wopiContext.FileReference.String()).Msg("CheckFileInfo: creating the file version url failed")
+		}
+		fileInfo.FileVersionUrl = fileVersionURL
+	}
+
+	// empty files, eg. created by the "New document" dialog, are created from a blank template
+	if statRes.Info.Size == 0 && wopiContext.VersionKey == "" && fileInfo.UserCanWrite && app.templateStore.Exists(fileInfo.FileExtension) {
+		templateSource, err := app.fileTemplateURL(wopiContext)
+		if err != nil {
+			app.Logger.Error().Err(err).Str("FileReference", wopiContext.FileReference.String()).Msg("CheckFileInfo: creating the template url failed")
+		}
+		fileInfo.TemplateSource = templateSource
+	}
+
+	app.setCollaboraOptions(&fileInfo, wopiContext)
+
+	// the breadcrumb folder is optional, we don't fail if it can't be resolved
+	parent, err := app.parentInfo(ctx, statRes.Info)
+	if err != nil {
+		app.Logger.Warn().Err(err).Str("FileReference", wopiContext.FileReference.String()).Msg("CheckFileInfo: resolving the parent folder failed")
+	}
+	if parent != nil {
+		fileInfo.BreadcrumbFolderName = containerName(parent)
+		fileInfo.BreadcrumbFolderUrl = app.webFolderURL(parent.Id)
+	}
+
+	if userID, ok := userInfoID(wopiContext.User); ok {
+		fileInfo.SupportsUserInfo = true
+
+		userInfo, err := app.userInfoStore.GetUserInfo(ctx, userID, fileRefFromResourceID(wopiContext.FileReference.ResourceId))
+		if err != nil {
+			app.Logger.Error().Err(err).Str("FileReference", wopiContext.FileReference.String()).Msg("CheckFileInfo: GetUserInfo failed")
+		}
+		fileInfo.UserInfo = userInfo
+	}
+
 	jsonFileInfo, err := json.Marshal(fileInfo)
 	if err != nil {
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
@@ -109,3 +211,92 @@ func CheckFileInfo(app *demoApp, w http.ResponseWriter, r *http.Request) {
 	w.Write(jsonFileInfo)
 	w.WriteHeader(http.StatusOK)
 }
+
+// userInfoID returns the id of the user, that is used to store the UserInfo.
+// Anonymous users and public link users don't have a stable id.
+func userInfoID(user *userv1beta1.User) (string, bool) {
+	if user == nil {
+		return "", false
+	}
+	if user.Opaque != nil {
+		if _, ok := user.Opaque.Map["public-share-role"]; ok {
+			return "", false
+		}
+	}
+	return user.Id.OpaqueId + "@" + user.Id.Idp, true
+}
+
+// setCollaboraOptions sets the Collabora specific properties from the configuration and the session
+// https://sdk.collaboraonline.com/docs/advanced_integration.html
+func (app *demoApp) setCollaboraOptions(fileInfo *FileInfo, wopiContext WopiContext) {
+	options := app.Config.Collabora
+	session := wopiContext.Collabora
+
+	fileInfo.PostMessageOrigin = options.PostMessageOrigin
+	if fileInfo.PostMessageOrigin == "" {
+		// ownCloud Web is the host page of the WOPI client
+		fileInfo.PostMessageOrigin = urlOrigin(app.Config.Web.URL)
+	}
+
+	// view only sessions disable these options anyway, read-only sessions can't save
+	fileInfo.HidePrintOption = options.HidePrintOption || session.HidePrintOption || fileInfo.DisablePrint
+	fileInfo.HideExportOption = options.HideExportOption || session.HideExportOption || fileInfo.DisableExport
+	fileInfo.HideSaveOption = options.HideSaveOption || session.HideSaveOption || !fileInfo.UserCanWrite
+
+	fileInfo.EnableInsertRemoteImage = options.EnableInsertRemoteImage
+	fileInfo.DisableInactiveMessages = options.DisableInactiveMessages || session.DisableInactiveMessages
+	fileInfo.SaveAsPostmessage = options.SaveAsPostmessage || session.SaveAsPostmessage
+	fileInfo.IsUserLocked = options.LockGuestUsers && fileInfo.IsAnonymousUser
+
+	// anonymous users and public link users have no avatar and email address we could show
+	var mail string
+	if _, ok := userInfoID(wopiContext.User); ok {
+		mail = wopiContext.User.Mail
+
+		userExtraInfo := UserExtraInfo{
+			Mail: mail,
+		}
+		if options.AvatarURL != "" {
+			userExtraInfo.Avatar = strings.ReplaceAll(options.AvatarURL, "{user_id}", url.PathEscape(wopiContext.User.Id.OpaqueId))
+		}
+		if userExtraInfo != (UserExtraInfo{}) {
+			fileInfo.UserExtraInfo = &userExtraInfo
+		}
+	}
+
+	// the watermark of the session is added to the configured one, it must not remove the watermark of the config
+	watermarkText := options.WatermarkText
+	if session.WatermarkText != "" {
+		if strings.TrimSpace(watermarkText) != "" {
+			watermarkText += " - "
+		}
+		watermarkText += session.WatermarkText
+	}
+	if watermarkText != "" {
+		fileInfo.WatermarkText = strings.NewReplacer(
+			"{user}", fileInfo.UserFriendlyName,
+			"{email}", mail,
+		).Replace(watermarkText)
+	}
+}
+
+// lastModifiedTime formats a CS3 timestamp in the ISO 8601 round-trip format used by WOPI
+func lastModifiedTime(mtime *typesv1beta1.Timestamp) string {
+	if mtime == nil {
+		return ""
+	}
+	return time.Unix(int64(mtime.Seconds), int64(mtime.Nanos)).UTC().Format(time.RFC3339Nano)
+}
+
+// itemVersion returns the WOPI version of a file, which is derived from the CS3 etag
+func itemVersion(etag string) string {
+	return strings.Trim(strings.TrimPrefix(etag, "W/"), "\"")
+}
+
+// fileVersionItemVersion returns the WOPI version of a previous version of a file
+func fileVersionItemVersion(version *providerv1beta1.FileVersion) string {
+	if version.Etag != "" {
+		return itemVersion(version.Etag)
+	}
+	return version.Key
+}
@@ -0,0 +1,107 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	appproviderv1beta1 "github.com/cs3org/go-cs3apis/cs3/app/provider/v1beta1"
+	typesv1beta1 "github.com/cs3org/go-cs3apis/cs3/types/v1beta1"
+)
+
+func checkFileInfo(t *testing.T, app *demoApp, wopiContext WopiContext) FileInfo {
+	t.Helper()
+
+	res := httptest.NewRecorder()
+	app.router().ServeHTTP(res, httptest.NewRequest(http.MethodGet, wopiURL(t, app, wopiContext, ""), nil))
+	if res.Code != http.StatusOK {
+		t.Fatalf("CheckFileInfo = %d, want 200", res.Code)
+	}
+
+	fileInfo := FileInfo{}
+	if err := json.Unmarshal(res.Body.Bytes(), &fileInfo); err != nil {
+		t.Fatal(err)
+	}
+	return fileInfo
+}
+
+func TestOpenInAppCollaboraSession(t *testing.T) {
+	plain := func(value string) *typesv1beta1.OpaqueEntry {
+		return &typesv1beta1.OpaqueEntry{Decoder: "plain", Value: []byte(value)}
+	}
+	req := &appproviderv1beta1.OpenInAppRequest{
+		Opaque: &typesv1beta1.Opaque{
+			Map: map[string]*typesv1beta1.OpaqueEntry{
+				"collabora_hide_print_option":   plain("true"),
+				"collabora_hide_export_option":  plain("no"),
+				"collabora_watermark_text":      plain("Confidential {user}"),
+				"collabora_save_as_postmessage": {Decoder: "json", Value: []byte("true")},
+			},
+		},
+	}
+
+	want := CollaboraSession{
+		HidePrintOption: true,
+		WatermarkText:   "Confidential {user}",
+	}
+	if got := openInAppCollaboraSession(req); got != want {
+		t.Fatalf("openInAppCollaboraSession = %+v, want %+v", got, want)
+	}
+	if got := openInAppCollaboraSession(&appproviderv1beta1.OpenInAppRequest{}); got != (CollaboraSession{}) {
+		t.Fatalf("openInAppCollaboraSession without opaque = %+v, want no options", got)
+	}
+}
+
+func TestCheckFileInfoCollaboraSession(t *testing.T) {
+	info := testFileInfo()
+	app := newTestApp(t, &fakeGateway{info: info})
+	app.Config.Collabora = Collabora{
+		HideExportOption: true,
+		WatermarkText:    "{user}",
+	}
+
+	// without session options the config is used
+	fileInfo := checkFileInfo(t, app, newTestWopiContext(t, info))
+	if fileInfo.HidePrintOption || !fileInfo.HideExportOption || fileInfo.WatermarkText != "einstein" {
+		t.Fatalf("CheckFileInfo = %+v, want the options of the config", fileInfo)
+	}
+
+	// the options of the session are carried by the access token
+	wopiContext := newTestWopiContext(t, info)
+	wopiContext.Collabora = CollaboraSession{
+		HidePrintOption: true,
+		WatermarkText:   "Confidential, {user}",
+	}
+	fileInfo = checkFileInfo(t, app, wopiContext)
+	if !fileInfo.HidePrintOption || !fileInfo.HideExportOption || fileInfo.WatermarkText != "einstein - Confidential, einstein" {
+		t.Fatalf("CheckFileInfo = %+v, want the options of the session on top of the config", fileInfo)
+	}
+
+	// other sessions of the file aren't affected
+	fileInfo = checkFileInfo(t, app, newTestWopiContext(t, info))
+	if fileInfo.HidePrintOption || fileInfo.WatermarkText != "einstein" {
+		t.Fatalf("CheckFileInfo of another session = %+v, want the options of the config", fileInfo)
+	}
+}
+
+func TestCheckFileInfoCollaboraSessionKeepsTheConfiguredWatermark(t *testing.T) {
+	info := testFileInfo()
+	app := newTestApp(t, &fakeGateway{info: info})
+	wopiContext := newTestWopiContext(t, info)
+	wopiContext.Collabora = CollaboraSession{WatermarkText: "Confidential"}
+
+	for _, tt := range []struct {
+		configured string
+		want       string
+	}{
+		{"", "Confidential"},
+		// a blank watermark is still a watermark, the session must not replace it
+		{" ", " Confidential"},
+	} {
+		app.Config.Collabora.WatermarkText = tt.configured
+		if fileInfo := checkFileInfo(t, app, wopiContext); fileInfo.WatermarkText != tt.want {
+			t.Fatalf("WatermarkText with %q configured = %q, want %q", tt.configured, fileInfo.WatermarkText, tt.want)
+		}
+	}
+}
@@ -1,18 +1,29 @@
 package app
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"net/http"
+	"strings"
+	"sync"
 	"time"
 
 	rpcv1beta1 "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
 	providerv1beta1 "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
 	typesv1beta1 "github.com/cs3org/go-cs3apis/cs3/types/v1beta1"
+	"github.com/wkloucek/cs3-wopi-server/pkg/internal/lockstore"
 )
 
 const (
 	// WOPI Locks generally have a lock duration of 30 minutes and will be refreshed before expiration if needed
 	// https://docs.microsoft.com/en-us/microsoft-365/cloud-storage-partner-program/rest/concepts#lock
 	lockDuration time.Duration = 30 * time.Minute
+
+	// the etag of the file content the WOPI session works on is stored in the opaque of the lock, so that it is
+	// shared by all WOPI server instances and survives restarts
+	lockETagOpaqueKey string = "wopi_session_etag"
 )
 
 // GetLock returns a lock or an empty string if no lock exists
@@ -21,28 +32,21 @@ func GetLock(app *demoApp, w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	wopiContext, _ := WopiContextFromCtx(ctx)
 
-	req := &providerv1beta1.GetLockRequest{
-		Ref: &wopiContext.FileReference,
-	}
-	resp, err := app.gwc.GetLock(
-		ctx,
-		req,
-	)
+	lock, err := app.lockStore.GetLock(ctx, &wopiContext.FileReference)
 	if err != nil {
 		app.Logger.Error().Err(err).Str("FileReference", wopiContext.FileReference.String()).Msg("GetLock failed")
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-		return
-	}
-
-	if resp.Status.Code != rpcv1beta1.Code_CODE_OK {
-		app.Logger.Error().Str("status_code", resp.Status.Code.String()).Str("status_msg", resp.Status.Message).Str("FileReference", wopiContext.FileReference.String()).Msg("GetLock failed")
-		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		cs3Error(w, err)
 		return
 	}
 
 	lockID := ""
-	if resp.Lock != nil {
-		lockID = resp.Lock.LockId
+	if lock != nil {
+		if !app.isWopiLock(lock) {
+			app.Logger.Warn().Str("current_lock_id", lock.LockId).Str("lock_app_name", lock.AppName).Str("FileReference", wopiContext.FileReference.String()).Msg("GetLock: file is locked by another app")
+			lockConflict(w, lock, "File locked by another app")
+			return
+		}
+		lockID = lock.LockId
 	}
 	w.Header().Set(HeaderWopiLock, lockID)
 	http.Error(w, http.StatusText(http.StatusOK), http.StatusOK)
@@ -55,91 +59,165 @@ func Lock(app *demoApp, w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	wopiContext, _ := WopiContextFromCtx(ctx)
 
-	// TODO: handle un- and relock
-
 	lockID := r.Header.Get(HeaderWopiLock)
 	if lockID == "" {
 		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 		return
 	}
 
-	req := &providerv1beta1.SetLockRequest{
+	if oldLockID := r.Header.Get(HeaderWopiOldLock); oldLockID != "" {
+		unlockAndRelock(app, w, r, lockID, oldLockID)
+		return
+	}
+
+	// the WOPI session works on the current content of the file
+	etag := ""
+	statRes, err := app.gwc.Stat(ctx, &providerv1beta1.StatRequest{
 		Ref: &wopiContext.FileReference,
-		Lock: &providerv1beta1.Lock{
-			LockId:  lockID,
-			AppName: app.Config.AppLockName,
-			Type:    providerv1beta1.LockType_LOCK_TYPE_WRITE,
-			Expiration: &typesv1beta1.Timestamp{
-				Seconds: uint64(time.Now().Add(lockDuration).Unix()),
-			},
-		},
+	})
+	if err != nil || statRes.Status.Code != rpcv1beta1.Code_CODE_OK {
+		app.Logger.Warn().Err(err).Str("lock_id", lockID).Str("FileReference", wopiContext.FileReference.String()).Msg("Lock: stat failed, modifications outside of the WOPI session can't be detected")
+	} else {
+		etag = statRes.Info.Etag
 	}
 
 	app.Logger.Debug().Str("lock_id", lockID).Str("FileReference", wopiContext.FileReference.String()).Msg("Performing SetLock")
-	resp, err := app.gwc.SetLock(
-		ctx,
-		req,
-	)
-	if err != nil {
-		app.Logger.Error().Err(err).Str("lock_id", lockID).Str("FileReference", wopiContext.FileReference.String()).Msg("SetLock failed")
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	err = app.lockStore.SetLock(ctx, &wopiContext.FileReference, app.newLock(lockID, etag))
+	switch {
+	case err == nil:
+		app.lockSessions.remember(wopiContext, lockID)
+		http.Error(w, http.StatusText(http.StatusOK), http.StatusOK)
 		return
-	}
 
-	switch resp.Status.Code {
-	case rpcv1beta1.Code_CODE_OK:
-		http.Error(w, http.StatusText(http.StatusOK), http.StatusOK)
+	case errors.Is(err, lockstore.ErrNotFound):
+		app.Logger.Error().Err(err).Str("lock_id", lockID).Str("FileReference", wopiContext.FileReference.String()).Msg("SetLock failed, file reference not found")
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
 		return
 
-	case rpcv1beta1.Code_CODE_FAILED_PRECONDITION:
+	case errors.Is(err, lockstore.ErrConflict), errors.Is(err, lockstore.ErrPermissionDenied):
 		// already locked
-		req := &providerv1beta1.GetLockRequest{
-			Ref: &wopiContext.FileReference,
-		}
-		resp, err := app.gwc.GetLock(
-			ctx,
-			req,
-		)
-		if err != nil {
-			app.Logger.Error().Err(err).Str("lock_id", lockID).Str("FileReference", wopiContext.FileReference.String()).Msg("SetLock failed, fallback to GetLock failed too")
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		currentLock, getLockErr := app.lockStore.GetLock(ctx, &wopiContext.FileReference)
+		if getLockErr != nil {
+			app.Logger.Error().Err(getLockErr).Str("lock_id", lockID).Str("FileReference", wopiContext.FileReference.String()).Msg("SetLock failed, fallback to GetLock failed too")
+			cs3Error(w, getLockErr)
 			return
 		}
 
-		if resp.Status.Code != rpcv1beta1.Code_CODE_OK {
-			app.Logger.Error().Str("status_code", resp.Status.Code.String()).Str("status_msg", resp.Status.Message).Str("lock_id", lockID).Str("FileReference", wopiContext.FileReference.String()).Msg("SetLock failed, fallback to GetLock failed too")
+		if currentLock == nil {
+			app.Logger.Error().Err(err).Str("lock_id", lockID).Str("FileReference", wopiContext.FileReference.String()).Msg("SetLock failed, but the file is not locked")
 			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
 		}
 
-		if resp.Lock != nil {
-			if resp.Lock.LockId != lockID {
-				w.Header().Set(HeaderWopiLock, resp.Lock.LockId)
-				http.Error(w, http.StatusText(http.StatusConflict), http.StatusConflict)
-				return
-			}
+		if !app.isWopiLock(currentLock) {
+			// eg. a WebDAV client or the web UI holds a lock on the file
+			app.Logger.Warn().Str("lock_id", lockID).Str("current_lock_id", currentLock.LockId).Str("lock_app_name", currentLock.AppName).Str("FileReference", wopiContext.FileReference.String()).Msg("SetLock failed, file is locked by another app")
+			lockConflict(w, currentLock, "File locked by another app")
+			return
+		}
 
-			// TODO: according to the spec we need to treat this as a RefreshLock
+		if currentLock.LockId != lockID {
+			app.Logger.Warn().Str("lock_id", lockID).Str("current_lock_id", currentLock.LockId).Str("FileReference", wopiContext.FileReference.String()).Msg("SetLock failed, lock mismatch")
+			lockConflict(w, currentLock, "Lock mismatch")
+			return
+		}
 
-			http.Error(w, http.StatusText(http.StatusOK), http.StatusOK)
+		// the file is already locked with the same lock id, the spec requires to treat this as a RefreshLock
+		if err := app.lockStore.RefreshLock(ctx, &wopiContext.FileReference, app.newLock(lockID, lockETag(currentLock)), ""); err != nil {
+			app.Logger.Error().Err(err).Str("lock_id", lockID).Str("FileReference", wopiContext.FileReference.String()).Msg("SetLock failed, fallback to RefreshLock failed too")
+			cs3Error(w, err)
 			return
 		}
 
-		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		app.lockSessions.remember(wopiContext, lockID)
+		http.Error(w, http.StatusText(http.StatusOK), http.StatusOK)
 		return
 
 	default:
-		app.Logger.Error().Str("status_code", resp.Status.Code.String()).Str("status_msg", resp.Status.Message).Str("lock_id", lockID).Str("FileReference", wopiContext.FileReference.String()).Msg("SetLock failed")
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		app.Logger.Error().Err(err).Str("lock_id", lockID).Str("FileReference", wopiContext.FileReference.String()).Msg("SetLock failed")
+		cs3Error(w, err)
 		return
 	}
+}
+
+// unlockAndRelock replaces the lock oldLockID with lockID without unlocking the file in between
+// https://docs.microsoft.com/en-us/microsoft-365/cloud-storage-partner-program/rest/files/unlockandrelock
+func unlockAndRelock(app *demoApp, w http.ResponseWriter, r *http.Request, lockID string, oldLockID string) {
+	ctx := r.Context()
+	wopiContext, _ := WopiContextFromCtx(ctx)
+
+	// the new lock continues the WOPI session of the old lock
+	etag, ok := currentLockETag(app, w, r, "UnlockAndRelock", oldLockID)
+	if !ok {
+		return
+	}
+
+	app.Logger.Debug().Str("lock_id", lockID).Str("old_lock_id", oldLockID).Str("FileReference", wopiContext.FileReference.String()).Msg("Performing UnlockAndRelock")
+	// refreshing the lock with an existing lock id swaps the lock atomically
+	err := app.lockStore.RefreshLock(ctx, &wopiContext.FileReference, app.newLock(lockID, etag), oldLockID)
+	switch {
+	case err == nil:
+		app.lockSessions.remember(wopiContext, lockID)
+		http.Error(w, http.StatusText(http.StatusOK), http.StatusOK)
+		return
 
+	case errors.Is(err, lockstore.ErrNotFound):
+		app.Logger.Error().Err(err).Str("lock_id", lockID).Str("old_lock_id", oldLockID).Str("FileReference", wopiContext.FileReference.String()).Msg("UnlockAndRelock failed, file reference not found")
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+
+	case errors.Is(err, lockstore.ErrConflict), errors.Is(err, lockstore.ErrPermissionDenied):
+		// either the file is not locked or the old lock id doesn't match
+		respondLockConflict(app, w, r, "UnlockAndRelock", oldLockID, err)
+		return
+
+	default:
+		app.Logger.Error().Err(err).Str("lock_id", lockID).Str("old_lock_id", oldLockID).Str("FileReference", wopiContext.FileReference.String()).Msg("UnlockAndRelock failed")
+		cs3Error(w, err)
+		return
+	}
 }
 
 // RefreshLock refreshes a provided lock for 30 minutes
 // https://docs.microsoft.com/en-us/microsoft-365/cloud-storage-partner-program/rest/files/refreshlock
 func RefreshLock(app *demoApp, w http.ResponseWriter, r *http.Request) {
-	// TODO: implement
-	http.Error(w, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
+	ctx := r.Context()
+	wopiContext, _ := WopiContextFromCtx(ctx)
+
+	lockID := r.Header.Get(HeaderWopiLock)
+	if lockID == "" {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	etag, ok := currentLockETag(app, w, r, "RefreshLock", lockID)
+	if !ok {
+		return
+	}
+
+	app.Logger.Debug().Str("lock_id", lockID).Str("FileReference", wopiContext.FileReference.String()).Msg("Performing RefreshLock")
+	err := app.lockStore.RefreshLock(ctx, &wopiContext.FileReference, app.newLock(lockID, etag), "")
+	switch {
+	case err == nil:
+		app.lockSessions.remember(wopiContext, lockID)
+		http.Error(w, http.StatusText(http.StatusOK), http.StatusOK)
+		return
+
+	case errors.Is(err, lockstore.ErrNotFound):
+		app.Logger.Error().Err(err).Str("lock_id", lockID).Str("FileReference", wopiContext.FileReference.String()).Msg("RefreshLock failed, file reference not found")
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+
+	case errors.Is(err, lockstore.ErrConflict), errors.Is(err, lockstore.ErrPermissionDenied):
+		// either the file is not locked or it is locked with a different lock id
+		respondLockConflict(app, w, r, "RefreshLock", lockID, err)
+		return
+
+	default:
+		app.Logger.Error().Err(err).Str("lock_id", lockID).Str("FileReference", wopiContext.FileReference.String()).Msg("RefreshLock failed")
+		cs3Error(w, err)
+		return
+	}
 }
 
 // UnLock removes a given lock from a file
@@ -154,29 +232,280 @@ func UnLock(app *demoApp, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	req := &providerv1beta1.UnlockRequest{
-		Ref: &wopiContext.FileReference,
-		Lock: &providerv1beta1.Lock{
-			LockId:  lockID,
-			AppName: app.Config.AppLockName,
+	lock := &providerv1beta1.Lock{
+		LockId:  lockID,
+		AppName: app.Config.AppLockName,
+	}
+
+	err := app.lockStore.Unlock(ctx, &wopiContext.FileReference, lock)
+	switch {
+	case err == nil:
+		app.lockSessions.forget(wopiContext)
+		http.Error(w, http.StatusText(http.StatusOK), http.StatusOK)
+		return
+
+	case errors.Is(err, lockstore.ErrNotFound):
+		app.Logger.Error().Err(err).Str("lock_id", lockID).Str("FileReference", wopiContext.FileReference.String()).Msg("UnLock failed, file reference not found")
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+
+	case errors.Is(err, lockstore.ErrConflict), errors.Is(err, lockstore.ErrPermissionDenied):
+		// either the file is not locked or it is locked with a different lock id
+		respondLockConflict(app, w, r, "UnLock", lockID, err)
+		return
+
+	default:
+		app.Logger.Error().Err(err).Str("lock_id", lockID).Str("FileReference", wopiContext.FileReference.String()).Msg("UnLock failed")
+		cs3Error(w, err)
+		return
+	}
+}
+
+// newLock returns a WOPI lock owned by this app, that expires after the lock duration.
+// The etag of the file content the WOPI session works on is stored with the lock.
+func (app *demoApp) newLock(lockID string, etag string) *providerv1beta1.Lock {
+	return &providerv1beta1.Lock{
+		Opaque:  lockETagOpaque(etag),
+		LockId:  lockID,
+		AppName: app.Config.AppLockName,
+		Type:    providerv1beta1.LockType_LOCK_TYPE_WRITE,
+		Expiration: &typesv1beta1.Timestamp{
+			Seconds: uint64(time.Now().Add(lockDuration).Unix()),
 		},
 	}
+}
+
+// lockWithETag returns a copy of the lock with another etag of the file content, the expiration is kept
+func lockWithETag(lock *providerv1beta1.Lock, etag string) *providerv1beta1.Lock {
+	return &providerv1beta1.Lock{
+		Opaque:     lockETagOpaque(etag),
+		LockId:     lock.LockId,
+		AppName:    lock.AppName,
+		Type:       lock.Type,
+		User:       lock.User,
+		Expiration: lock.Expiration,
+	}
+}
 
-	resp, err := app.gwc.Unlock(
-		ctx,
-		req,
-	)
+// lockETagOpaque returns the opaque of a lock with the etag of the file content
+func lockETagOpaque(etag string) *typesv1beta1.Opaque {
+	if etag == "" {
+		return nil
+	}
+	return &typesv1beta1.Opaque{
+		Map: map[string]*typesv1beta1.OpaqueEntry{
+			lockETagOpaqueKey: {
+				Decoder: "plain",
+				Value:   []byte(etag),
+			},
+		},
+	}
+}
+
+// lockETag returns the etag of the file content the WOPI session of the lock works on or an empty string if it is unknown
+func lockETag(lock *providerv1beta1.Lock) string {
+	entry, ok := lock.GetOpaque().GetMap()[lockETagOpaqueKey]
+	if !ok || entry.GetDecoder() != "plain" {
+		return ""
+	}
+	return string(entry.GetValue())
+}
+
+// currentLockETag returns the etag stored with the current lock of the file, if it has the lock id.
+// It responds with an error and returns false if the lock can't be looked up.
+func currentLockETag(app *demoApp, w http.ResponseWriter, r *http.Request, operation string, lockID string) (string, bool) {
+	ctx := r.Context()
+	wopiContext, _ := WopiContextFromCtx(ctx)
+
+	lock, err := app.lockStore.GetLock(ctx, &wopiContext.FileReference)
 	if err != nil {
-		app.Logger.Error().Err(err).Str("lock_id", lockID).Str("FileReference", wopiContext.FileReference.String()).Msg("UnLock failed")
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		app.Logger.Error().Err(err).Str("lock_id", lockID).Str("FileReference", wopiContext.FileReference.String()).Msg(operation + ": GetLock failed")
+		cs3Error(w, err)
+		return "", false
+	}
+
+	if lock == nil || lock.LockId != lockID {
+		// the lock operation fails and responds with the conflict
+		return "", true
+	}
+	return lockETag(lock), true
+}
+
+// checkWopiLock validates the lock id sent by the WOPI client against the current lock of the file.
+// If the file is locked by someone else, it responds with 409 Conflict and returns false.
+// Otherwise it returns the lock id that needs to be sent to the storage along with the operation.
+func checkWopiLock(app *demoApp, w http.ResponseWriter, r *http.Request, operation string, lockID string) (string, bool) {
+	ctx := r.Context()
+	wopiContext, _ := WopiContextFromCtx(ctx)
+
+	lock, err := app.lockStore.GetLock(ctx, &wopiContext.FileReference)
+	if err != nil {
+		app.Logger.Error().Err(err).Str("lock_id", lockID).Str("FileReference", wopiContext.FileReference.String()).Msg(operation + ": GetLock failed")
+		cs3Error(w, err)
+		return "", false
+	}
+
+	if lock == nil {
+		// operations on unlocked files are allowed
+		return "", true
+	}
+
+	if !app.isWopiLock(lock) {
+		app.Logger.Warn().Str("lock_id", lockID).Str("lock_app_name", lock.AppName).Str("FileReference", wopiContext.FileReference.String()).Msg(operation + ": file is locked by another app")
+		lockConflict(w, lock, "File locked by another app")
+		return "", false
+	}
+
+	if lock.LockId != lockID {
+		app.Logger.Warn().Str("lock_id", lockID).Str("current_lock_id", lock.LockId).Str("FileReference", wopiContext.FileReference.String()).Msg(operation + ": lock mismatch")
+		lockConflict(w, lock, "Lock mismatch")
+		return "", false
+	}
+
+	return app.lockStore.StorageLockID(ctx, &wopiContext.FileReference, lockID), true
+}
+
+// respondLockConflict looks up the current lock after a failed lock operation and responds with 409 Conflict
+// or, if the operation was denied for another reason than a lock held by a non-WOPI lock owner, with 500 Internal Server Error
+func respondLockConflict(app *demoApp, w http.ResponseWriter, r *http.Request, operation string, lockID string, opErr error) {
+	ctx := r.Context()
+	wopiContext, _ := WopiContextFromCtx(ctx)
+
+	currentLock, err := app.lockStore.GetLock(ctx, &wopiContext.FileReference)
+	if err != nil {
+		app.Logger.Error().Err(err).Str("lock_id", lockID).Str("FileReference", wopiContext.FileReference.String()).Msg(operation + " failed, fallback to GetLock failed too")
+		cs3Error(w, err)
+		return
+	}
+
+	if currentLock != nil && !app.isWopiLock(currentLock) {
+		app.Logger.Warn().Str("lock_id", lockID).Str("current_lock_id", currentLock.LockId).Str("lock_app_name", currentLock.AppName).Str("FileReference", wopiContext.FileReference.String()).Msg(operation + " failed, file is locked by another app")
+		lockConflict(w, currentLock, "File locked by another app")
 		return
 	}
 
-	if resp.Status.Code != rpcv1beta1.Code_CODE_OK {
-		app.Logger.Error().Str("status_code", resp.Status.Code.String()).Str("status_msg", resp.Status.Message).Str("lock_id", lockID).Str("FileReference", wopiContext.FileReference.String()).Msg("UnLock failed")
+	if errors.Is(opErr, lockstore.ErrPermissionDenied) {
+		app.Logger.Error().Err(opErr).Str("lock_id", lockID).Str("FileReference", wopiContext.FileReference.String()).Msg(operation + " failed, permission denied")
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
 
-	http.Error(w, http.StatusText(http.StatusOK), http.StatusOK)
+	if currentLock == nil {
+		// the spec requires a 409 with an empty lock header if the file is not locked
+		app.Logger.Warn().Str("lock_id", lockID).Str("FileReference", wopiContext.FileReference.String()).Msg(operation + " failed, file is not locked")
+		lockConflict(w, nil, "File not locked")
+		return
+	}
+
+	app.Logger.Warn().Str("lock_id", lockID).Str("current_lock_id", currentLock.LockId).Str("FileReference", wopiContext.FileReference.String()).Msg(operation + " failed, lock mismatch")
+	lockConflict(w, currentLock, "Lock mismatch")
+}
+
+// storageLockConflict responds to an operation that the storage refused because of a lock. If the lock isn't
+// known to the lock store, eg. a lock the storage doesn't report, there is no lock id to return, only the reason.
+func storageLockConflict(app *demoApp, w http.ResponseWriter, r *http.Request, operation string) {
+	ctx := r.Context()
+	wopiContext, _ := WopiContextFromCtx(ctx)
+
+	lock, err := app.lockStore.GetLock(ctx, &wopiContext.FileReference)
+	if err != nil {
+		app.Logger.Error().Err(err).Str("FileReference", wopiContext.FileReference.String()).Msg(operation + " failed, fallback to GetLock failed too")
+		cs3Error(w, err)
+		return
+	}
+
+	if lock != nil {
+		app.Logger.Warn().Str("current_lock_id", lock.LockId).Str("FileReference", wopiContext.FileReference.String()).Msg(operation + " failed, file is locked")
+		lockConflict(w, lock, "File locked")
+		return
+	}
+
+	app.Logger.Warn().Str("FileReference", wopiContext.FileReference.String()).Msg(operation + " failed, file is locked in the storage")
+	w.Header().Set(HeaderWopiLockFailureReason, "File locked in the storage")
+	http.Error(w, http.StatusText(http.StatusConflict), http.StatusConflict)
+}
+
+// lockConflict responds with 409 Conflict, the current lock id and the reason why the lock operation failed.
+// If the current lock is known, the lock holder is appended to the reason.
+func lockConflict(w http.ResponseWriter, currentLock *providerv1beta1.Lock, reason string) {
+	currentLockID := ""
+	if currentLock != nil {
+		currentLockID = currentLock.LockId
+		if holder := lockHolder(currentLock); holder != "" {
+			reason = reason + ", " + holder
+		}
+	}
+
+	w.Header().Set(HeaderWopiLock, currentLockID)
+	w.Header().Set(HeaderWopiLockFailureReason, reason)
+	http.Error(w, http.StatusText(http.StatusConflict), http.StatusConflict)
+}
+
+// lockHolder describes who holds a CS3 lock, eg. `locked by app "Collabora" for user "einstein@idp" (write lock)`
+func lockHolder(lock *providerv1beta1.Lock) string {
+	// locks without an app name are set by other clients, eg. WebDAV clients
+	holder := "locked by another client"
+	if lock.AppName != "" {
+		holder = fmt.Sprintf("locked by app %q", lock.AppName)
+	}
+	if lock.User != nil && lock.User.OpaqueId != "" {
+		holder += fmt.Sprintf(" for user %q", lock.User.OpaqueId+"@"+lock.User.Idp)
+	}
+	if lock.Type != providerv1beta1.LockType_LOCK_TYPE_INVALID {
+		holder += fmt.Sprintf(" (%s lock)", strings.ToLower(strings.TrimPrefix(lock.Type.String(), "LOCK_TYPE_")))
+	}
+	return holder
+}
+
+// isWopiLock checks if the lock was set by this WOPI app
+func (app *demoApp) isWopiLock(lock *providerv1beta1.Lock) bool {
+	return lock.AppName == app.Config.AppLockName
+}
+
+// lockSessions remembers which sessions set or refreshed the WOPI locks, so that other pages of a session, eg. the
+// versions page, can tell if the file is locked by their own session. Sessions are told apart by the CS3 token they
+// were opened with. The sessions are only kept in memory, after a restart a lock is held by another session until
+// it is refreshed.
+type lockSessions struct {
+	mu sync.Mutex
+	// the lock ids by session, by file
+	lockIDs map[string]map[string]string
+}
+
+// remember records that the session of the wopi context holds the lock of the file
+func (s *lockSessions) remember(wopiContext WopiContext, lockID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.lockIDs == nil {
+		s.lockIDs = make(map[string]map[string]string)
+	}
+	fileRef := fileRefFromResourceID(wopiContext.FileReference.ResourceId)
+	if s.lockIDs[fileRef] == nil {
+		s.lockIDs[fileRef] = make(map[string]string)
+	}
+	s.lockIDs[fileRef][lockSession(wopiContext)] = lockID
+}
+
+// forget removes the sessions of the file once it is unlocked
+func (s *lockSessions) forget(wopiContext WopiContext) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.lockIDs, fileRefFromResourceID(wopiContext.FileReference.ResourceId))
+}
+
+// holds checks if the session of the wopi context holds the lock
+func (s *lockSessions) holds(wopiContext WopiContext, lock *providerv1beta1.Lock) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	lockID, ok := s.lockIDs[fileRefFromResourceID(wopiContext.FileReference.ResourceId)][lockSession(wopiContext)]
+	return ok && lockID == lock.LockId
+}
+
+// lockSession identifies the session of the wopi context without keeping its CS3 token
+func lockSession(wopiContext WopiContext) string {
+	sum := sha256.Sum256([]byte(wopiContext.AccessToken))
+	return hex.EncodeToString(sum[:])
 }
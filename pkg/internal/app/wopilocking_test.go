@@ -0,0 +1,111 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	providerv1beta1 "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+)
+
+func postLockRequest(t *testing.T, app *demoApp, wopiContext WopiContext, action string, lockID string, oldLockID string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodPost, wopiURL(t, app, wopiContext, ""), nil)
+	req.Header.Set("X-WOPI-Override", action)
+	req.Header.Set(HeaderWopiLock, lockID)
+	if oldLockID != "" {
+		req.Header.Set(HeaderWopiOldLock, oldLockID)
+	}
+	res := httptest.NewRecorder()
+	app.router().ServeHTTP(res, req)
+	return res
+}
+
+func currentTestLock(t *testing.T, app *demoApp, wopiContext WopiContext) *providerv1beta1.Lock {
+	t.Helper()
+
+	lock, err := app.lockStore.GetLock(context.Background(), &wopiContext.FileReference)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lock
+}
+
+func TestLockKeepsTheSessionETag(t *testing.T) {
+	info := testFileInfo()
+	info.Etag = `"etag-1"`
+	gwc := &fakeGateway{info: info}
+	app := newTestApp(t, gwc)
+	wopiContext := newTestWopiContext(t, info)
+
+	if res := postLockRequest(t, app, wopiContext, "LOCK", "a", ""); res.Code != http.StatusOK {
+		t.Fatalf("LOCK = %d, want 200", res.Code)
+	}
+	if etag := lockETag(currentTestLock(t, app, wopiContext)); etag != `"etag-1"` {
+		t.Fatalf("etag of the lock = %q, want the etag of the file when it was locked", etag)
+	}
+
+	// the file changes outside of the WOPI session, the session still works on the old content
+	info.Etag = `"etag-2"`
+
+	for _, tt := range []struct {
+		action    string
+		lockID    string
+		oldLockID string
+	}{
+		{"LOCK", "a", ""},
+		{"REFRESH_LOCK", "a", ""},
+		{"LOCK", "b", "a"},
+	} {
+		if res := postLockRequest(t, app, wopiContext, tt.action, tt.lockID, tt.oldLockID); res.Code != http.StatusOK {
+			t.Fatalf("%s %s = %d, want 200", tt.action, tt.lockID, res.Code)
+		}
+		lock := currentTestLock(t, app, wopiContext)
+		if lock.GetLockId() != tt.lockID || lockETag(lock) != `"etag-1"` {
+			t.Fatalf("lock after %s %s = %v, want lock %s with the etag of the session", tt.action, tt.lockID, lock, tt.lockID)
+		}
+	}
+}
+
+func TestLockWithETag(t *testing.T) {
+	app := newTestApp(t, &fakeGateway{})
+	lock := app.newLock("a", `"etag-1"`)
+
+	updated := lockWithETag(lock, `"etag-2"`)
+	if updated.GetLockId() != "a" || updated.GetExpiration().GetSeconds() != lock.GetExpiration().GetSeconds() {
+		t.Fatalf("lockWithETag = %v, want the lock with its expiration", updated)
+	}
+	if etag := lockETag(updated); etag != `"etag-2"` {
+		t.Fatalf("etag of the updated lock = %q, want the new etag", etag)
+	}
+	if etag := lockETag(lock); etag != `"etag-1"` {
+		t.Fatalf("etag of the original lock = %q, it must not be modified", etag)
+	}
+	if etag := lockETag(lockWithETag(lock, "")); etag != "" {
+		t.Fatalf("etag of a lock with an unknown etag = %q, want none", etag)
+	}
+}
+
+func TestCollaboraOverwrite(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    bool
+	}{
+		{"regular save", map[string]string{HeaderCoolWopiTimestamp: "2023-01-01T00:00:00.000000Z", HeaderCoolWopiIsModifiedByUser: "true"}, false},
+		{"overwrite", map[string]string{HeaderCoolWopiIsModifiedByUser: "true"}, true},
+		{"overwrite of a LibreOffice Online version", map[string]string{HeaderLoolWopiIsModifiedByUser: "false"}, true},
+		{"other WOPI client", map[string]string{}, false},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		for key, value := range tt.headers {
+			req.Header.Set(key, value)
+		}
+		if got := collaboraOverwrite(req); got != tt.want {
+			t.Errorf("collaboraOverwrite of a %s = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
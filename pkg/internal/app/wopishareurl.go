@@ -0,0 +1,212 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+
+	appproviderv1beta1 "github.com/cs3org/go-cs3apis/cs3/app/provider/v1beta1"
+	rpcv1beta1 "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
+	linkv1beta1 "github.com/cs3org/go-cs3apis/cs3/sharing/link/v1beta1"
+	providerv1beta1 "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+)
+
+const (
+	ShareUrlTypeReadOnly  string = "ReadOnly"
+	ShareUrlTypeReadWrite string = "ReadWrite"
+)
+
+// public link permissions for the supported share url types. The links point to a single file, the read-write
+// links get the permissions of the file editor role without the permissions to create, delete or move resources.
+var shareUrlPermissions = map[string]*providerv1beta1.ResourcePermissions{
+	ShareUrlTypeReadOnly: {
+		GetPath:              true,
+		GetQuota:             true,
+		InitiateFileDownload: true,
+		ListContainer:        true,
+		ListRecycle:          true,
+		Stat:                 true,
+	},
+	ShareUrlTypeReadWrite: {
+		GetPath:              true,
+		GetQuota:             true,
+		InitiateFileDownload: true,
+		InitiateFileUpload:   true,
+		ListContainer:        true,
+		ListFileVersions:     true,
+		ListRecycle:          true,
+		RestoreFileVersion:   true,
+		Stat:                 true,
+	},
+}
+
+type GetShareUrlResponse struct {
+	// A URI that can be used to access the file with the requested share url type.
+	ShareUrl string `json:"ShareUrl"`
+}
+
+// GetShareUrl returns a public link to the file, an existing public link with matching permissions is reused
+// https://docs.microsoft.com/en-us/microsoft-365/cloud-storage-partner-program/rest/files/getshareurl
+func GetShareUrl(app *demoApp, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	wopiContext, _ := WopiContextFromCtx(ctx)
+
+	urlType := r.Header.Get(HeaderWopiUrlType)
+
+	statRes, err := app.gwc.Stat(ctx, &providerv1beta1.StatRequest{
+		Ref: &wopiContext.FileReference,
+	})
+	if err != nil {
+		app.Logger.Error().Err(err).Str("FileReference", wopiContext.FileReference.String()).Msg("GetShareUrl: stat failed")
+		cs3Error(w, err)
+		return
+	}
+
+	if statRes.Status.Code != rpcv1beta1.Code_CODE_OK {
+		app.Logger.Error().Str("status_code", statRes.Status.Code.String()).Str("status_msg", statRes.Status.Message).Str("FileReference", wopiContext.FileReference.String()).Msg("GetShareUrl: stat failed")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	supported := false
+	for _, t := range app.supportedShareUrlTypes(wopiContext, statRes.Info) {
+		if t == urlType {
+			supported = true
+		}
+	}
+	if !supported {
+		http.Error(w, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
+		return
+	}
+	permissions := shareUrlPermissions[urlType]
+
+	listRes, err := app.gwc.ListPublicShares(ctx, &linkv1beta1.ListPublicSharesRequest{
+		Filters: []*linkv1beta1.ListPublicSharesRequest_Filter{
+			{
+				Type: linkv1beta1.ListPublicSharesRequest_Filter_TYPE_RESOURCE_ID,
+				Term: &linkv1beta1.ListPublicSharesRequest_Filter_ResourceId{
+					ResourceId: statRes.Info.Id,
+				},
+			},
+		},
+	})
+	if err != nil {
+		app.Logger.Error().Err(err).Str("FileReference", wopiContext.FileReference.String()).Msg("GetShareUrl: ListPublicShares failed")
+		cs3Error(w, err)
+		return
+	}
+
+	if listRes.Status.Code != rpcv1beta1.Code_CODE_OK {
+		app.Logger.Error().Str("status_code", listRes.Status.Code.String()).Str("status_msg", listRes.Status.Message).Str("FileReference", wopiContext.FileReference.String()).Msg("GetShareUrl: ListPublicShares failed")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	token := ""
+	for _, share := range listRes.Share {
+		if isReusablePublicShare(share, permissions) {
+			token = share.Token
+			break
+		}
+	}
+
+	if token == "" {
+		createRes, err := app.gwc.CreatePublicShare(ctx, &linkv1beta1.CreatePublicShareRequest{
+			ResourceInfo: statRes.Info,
+			Grant: &linkv1beta1.Grant{
+				Permissions: &linkv1beta1.PublicSharePermissions{
+					Permissions: permissions,
+				},
+			},
+		})
+		if err != nil {
+			app.Logger.Error().Err(err).Str("FileReference", wopiContext.FileReference.String()).Msg("GetShareUrl: CreatePublicShare failed")
+			cs3Error(w, err)
+			return
+		}
+
+		switch createRes.Status.Code {
+		case rpcv1beta1.Code_CODE_OK:
+			token = createRes.Share.Token
+
+		case rpcv1beta1.Code_CODE_PERMISSION_DENIED:
+			app.Logger.Warn().Str("status_msg", createRes.Status.Message).Str("FileReference", wopiContext.FileReference.String()).Msg("GetShareUrl: CreatePublicShare not permitted")
+			notAuthorized(w)
+			return
+
+		default:
+			app.Logger.Error().Str("status_code", createRes.Status.Code.String()).Str("status_msg", createRes.Status.Message).Str("FileReference", wopiContext.FileReference.String()).Msg("GetShareUrl: CreatePublicShare failed")
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	shareURL, err := url.Parse(app.Config.Web.URL)
+	if err != nil {
+		app.Logger.Error().Err(err).Msg("GetShareUrl: parsing the web url failed")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	shareURL.Path = path.Join(shareURL.Path, "s", token)
+
+	jsonResponse, err := json.Marshal(GetShareUrlResponse{
+		ShareUrl: shareURL.String(),
+	})
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
+
+// supportedShareUrlTypes returns the share url types the current user can create for the file
+func (app *demoApp) supportedShareUrlTypes(wopiContext WopiContext, info *providerv1beta1.ResourceInfo) []string {
+	if app.Config.Web.URL == "" {
+		// we can't build share urls without knowing the web url
+		return nil
+	}
+
+	if _, ok := userInfoID(wopiContext.User); !ok {
+		// anonymous users and public link users can't create public links
+		return nil
+	}
+
+	if !info.GetPermissionSet().GetAddGrant() {
+		return nil
+	}
+
+	urlTypes := []string{ShareUrlTypeReadOnly}
+	if wopiContext.ViewMode == appproviderv1beta1.OpenInAppRequest_VIEW_MODE_READ_WRITE && info.GetPermissionSet().GetInitiateFileUpload() {
+		urlTypes = append(urlTypes, ShareUrlTypeReadWrite)
+	}
+	return urlTypes
+}
+
+// isReusablePublicShare checks if a public share can be handed out for the given permissions
+func isReusablePublicShare(share *linkv1beta1.PublicShare, permissions *providerv1beta1.ResourcePermissions) bool {
+	if share.PasswordProtected {
+		return false
+	}
+	if share.Expiration != nil && time.Now().After(time.Unix(int64(share.Expiration.Seconds), int64(share.Expiration.Nanos))) {
+		return false
+	}
+	sharePermissions := share.GetPermissions().GetPermissions()
+	// read-write and read-only links are distinguished by the upload permission
+	if sharePermissions.GetInitiateFileUpload() != permissions.InitiateFileUpload ||
+		sharePermissions.GetInitiateFileDownload() != permissions.InitiateFileDownload {
+		return false
+	}
+	// links with more permissions than the share url type, eg. editor links created in the web UI, are not handed out
+	return (!sharePermissions.GetAddGrant() || permissions.AddGrant) &&
+		(!sharePermissions.GetCreateContainer() || permissions.CreateContainer) &&
+		(!sharePermissions.GetDelete() || permissions.Delete) &&
+		(!sharePermissions.GetMove() || permissions.Move) &&
+		(!sharePermissions.GetPurgeRecycle() || permissions.PurgeRecycle) &&
+		(!sharePermissions.GetRemoveGrant() || permissions.RemoveGrant) &&
+		(!sharePermissions.GetUpdateGrant() || permissions.UpdateGrant)
+}
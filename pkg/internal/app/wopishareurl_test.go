@@ -0,0 +1,61 @@
+package app
+
+import (
+	"testing"
+
+	linkv1beta1 "github.com/cs3org/go-cs3apis/cs3/sharing/link/v1beta1"
+	providerv1beta1 "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+)
+
+func TestShareUrlPermissionsReadWrite(t *testing.T) {
+	permissions := shareUrlPermissions[ShareUrlTypeReadWrite]
+
+	if !permissions.InitiateFileUpload || !permissions.InitiateFileDownload || !permissions.Stat {
+		t.Errorf("read-write share urls can't edit the file: %v", permissions)
+	}
+	// the links point to a single file, they must not allow to restructure the parent folder
+	if permissions.CreateContainer || permissions.Delete || permissions.Move || permissions.AddGrant {
+		t.Errorf("read-write share urls grant more than editing the file: %v", permissions)
+	}
+}
+
+func TestIsReusablePublicShare(t *testing.T) {
+	publicShare := func(permissions *providerv1beta1.ResourcePermissions) *linkv1beta1.PublicShare {
+		return &linkv1beta1.PublicShare{
+			Permissions: &linkv1beta1.PublicSharePermissions{Permissions: permissions},
+		}
+	}
+
+	readOnly := shareUrlPermissions[ShareUrlTypeReadOnly]
+	readWrite := shareUrlPermissions[ShareUrlTypeReadWrite]
+	editor := &providerv1beta1.ResourcePermissions{
+		CreateContainer:      true,
+		Delete:               true,
+		InitiateFileDownload: true,
+		InitiateFileUpload:   true,
+		Move:                 true,
+		Stat:                 true,
+	}
+
+	tests := []struct {
+		name        string
+		share       *linkv1beta1.PublicShare
+		permissions *providerv1beta1.ResourcePermissions
+		want        bool
+	}{
+		{"same read-only link", publicShare(readOnly), readOnly, true},
+		{"same read-write link", publicShare(readWrite), readWrite, true},
+		{"read-only link for read-write", publicShare(readOnly), readWrite, false},
+		{"read-write link for read-only", publicShare(readWrite), readOnly, false},
+		{"editor link with delete and move", publicShare(editor), readWrite, false},
+		{"password protected link", &linkv1beta1.PublicShare{
+			PasswordProtected: true,
+			Permissions:       &linkv1beta1.PublicSharePermissions{Permissions: readOnly},
+		}, readOnly, false},
+	}
+	for _, tt := range tests {
+		if got := isReusablePublicShare(tt.share, tt.permissions); got != tt.want {
+			t.Errorf("%s: isReusablePublicShare = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
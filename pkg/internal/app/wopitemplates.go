@@ -0,0 +1,256 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	appproviderv1beta1 "github.com/cs3org/go-cs3apis/cs3/app/provider/v1beta1"
+	rpcv1beta1 "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
+	providerv1beta1 "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	"github.com/cs3org/reva/v2/pkg/mime"
+	"github.com/wkloucek/cs3-wopi-server/pkg/internal/helpers"
+	"github.com/wkloucek/cs3-wopi-server/pkg/internal/templates"
+)
+
+// FileTemplate returns the blank template for an empty file, it is the TemplateSource in CheckFileInfo
+// https://sdk.collaboraonline.com/docs/advanced_integration.html
+func FileTemplate(app *demoApp, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	wopiContext, _ := WopiContextFromCtx(ctx)
+
+	statRes, err := app.gwc.Stat(ctx, &providerv1beta1.StatRequest{
+		Ref: &wopiContext.FileReference,
+	})
+	if err != nil {
+		app.Logger.Error().Err(err).Str("FileReference", wopiContext.FileReference.String()).Msg("FileTemplate: stat failed")
+		cs3Error(w, err)
+		return
+	}
+
+	switch statRes.Status.Code {
+	case rpcv1beta1.Code_CODE_OK:
+	case rpcv1beta1.Code_CODE_NOT_FOUND:
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	default:
+		app.Logger.Error().Str("status_code", statRes.Status.Code.String()).Str("status_msg", statRes.Status.Message).Str("FileReference", wopiContext.FileReference.String()).Msg("FileTemplate: stat failed")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	name := path.Base(statRes.Info.Path)
+	template, err := app.templateStore.Template(path.Ext(name))
+	if err != nil {
+		app.Logger.Error().Err(err).Str("FileReference", wopiContext.FileReference.String()).Msg("FileTemplate: reading the template failed")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if template == nil {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	writeTemplate(w, r, name, template)
+}
+
+// writeTemplate responds with the blank template for the file name, range requests are served like for the file
+func writeTemplate(w http.ResponseWriter, r *http.Request, name string, template []byte) {
+	w.Header().Set("Content-Type", mime.Detect(false, name))
+	http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(template))
+}
+
+// fileTemplateURL returns the url of the blank template for the file of the wopi context including an access token
+func (app *demoApp) fileTemplateURL(wopiContext WopiContext) (string, error) {
+	accessToken, _, err := app.newAccessToken(wopiContext)
+	if err != nil {
+		return "", err
+	}
+
+	templateURL := app.wopiSrcURL(fileRefFromResourceID(wopiContext.FileReference.ResourceId))
+	templateURL.Path = path.Join(templateURL.Path, "template")
+	templateURL.RawQuery = url.Values{"access_token": []string{accessToken}}.Encode()
+
+	return templateURL.String(), nil
+}
+
+type LibraryTemplate struct {
+	// The CS3 resource id of the template in the "storageid$spaceid!opaqueid" format.
+	Id string `json:"Id"`
+	// The name of the template, including extension, without a path.
+	Name string `json:"Name"`
+	// The path of the folder in the template library the template is in, empty for the top level.
+	Category string `json:"Category,omitempty"`
+	// The size of the template in bytes.
+	Size int64 `json:"Size"`
+	// The last time the template was modified, in ISO 8601 round-trip format.
+	LastModifiedTime string `json:"LastModifiedTime,omitempty"`
+}
+
+type EnumerateTemplatesResponse struct {
+	Templates []LibraryTemplate `json:"Templates"`
+}
+
+// EnumerateTemplates returns the templates of the template library, new files can be created from them in the
+// requested container. Only templates the WOPI client can open are returned.
+func EnumerateTemplates(app *demoApp, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	wopiContext, _ := WopiContextFromCtx(ctx)
+
+	if app.templateLibrary == nil {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	if _, ok := statContainer(app, w, r, "EnumerateTemplates"); !ok {
+		return
+	}
+
+	// optional comma separated list of file extensions to return, eg. ".docx,.xlsx"
+	var extensionFilter []string
+	if filter := r.URL.Query().Get("file_extension_filter"); filter != "" {
+		extensionFilter = strings.Split(filter, ",")
+	}
+
+	libraryTemplates, err := app.templateLibrary.Templates(ctx, wopiContext.User.GetId())
+	if errors.Is(err, templates.ErrLibraryNotFound) {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		app.Logger.Error().Err(err).Str("FileReference", wopiContext.FileReference.String()).Msg("EnumerateTemplates: indexing the template library failed")
+		cs3Error(w, err)
+		return
+	}
+
+	response := EnumerateTemplatesResponse{
+		Templates: []LibraryTemplate{},
+	}
+	for _, template := range libraryTemplates {
+		if !app.canOpen(template.Name) || !matchesExtensionFilter(template.Name, extensionFilter) {
+			continue
+		}
+
+		response.Templates = append(response.Templates, LibraryTemplate{
+			Id:               formatResourceID(template.Id),
+			Name:             template.Name,
+			Category:         template.Category,
+			Size:             int64(template.Size),
+			LastModifiedTime: lastModifiedTime(template.Mtime),
+		})
+	}
+
+	jsonResponse, err := json.Marshal(response)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
+
+// CreateChildFileFromTemplate creates a new file in the requested container by copying a template of the template
+// library. The request body is the id of the template, the name of the new file is taken from the X-WOPI-SuggestedTarget
+// or X-WOPI-RelativeTarget header like in CreateChildFile, the name of the template is used if both are missing.
+// The response contains the urls to open the new file.
+func CreateChildFileFromTemplate(app *demoApp, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	wopiContext, _ := WopiContextFromCtx(ctx)
+
+	defer r.Body.Close()
+
+	if wopiContext.ViewMode != appproviderv1beta1.OpenInAppRequest_VIEW_MODE_READ_WRITE {
+		notAuthorized(w)
+		return
+	}
+
+	if app.templateLibrary == nil {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	// read one more byte than allowed to detect oversized resource ids
+	body, err := io.ReadAll(io.LimitReader(r.Body, int64(maxResourceIDLength)+1))
+	if err != nil {
+		app.Logger.Error().Err(err).Msg("CreateChildFileFromTemplate: reading the body failed")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if len(body) > maxResourceIDLength {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	templateID, err := parseResourceID(strings.TrimSpace(string(body)))
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	// only templates of the library can be copied
+	template, err := app.templateLibrary.Template(ctx, wopiContext.User.GetId(), templateID)
+	if err != nil && !errors.Is(err, templates.ErrLibraryNotFound) {
+		app.Logger.Error().Err(err).Str("template_id", formatResourceID(templateID)).Msg("CreateChildFileFromTemplate: indexing the template library failed")
+		cs3Error(w, err)
+		return
+	}
+	if template == nil {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	info, ok := statContainer(app, w, r, "CreateChildFileFromTemplate")
+	if !ok {
+		return
+	}
+
+	resp, err := helpers.DownloadFile(
+		ctx,
+		&providerv1beta1.Reference{
+			ResourceId: template.Id,
+			Path:       ".",
+		},
+		app.gwc,
+		wopiContext.AccessToken,
+		"",
+		app.dataGatewayClient,
+		app.Logger,
+	)
+	if err != nil {
+		app.Logger.Error().Err(err).Str("template_id", formatResourceID(templateID)).Msg("CreateChildFileFromTemplate: downloading the template failed")
+		cs3Error(w, err)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		app.Logger.Error().Str("status_code", http.StatusText(resp.StatusCode)).Str("template_id", formatResourceID(templateID)).Msg("CreateChildFileFromTemplate: downloading the template failed")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	if r.Header.Get(HeaderWopiSuggestedTarget) == "" && r.Header.Get(HeaderWopiRelativeTarget) == "" {
+		// the new file is named after the template, a number is added if the name is already taken
+		r.Header.Set(HeaderWopiSuggestedTarget, encodeUTF7(template.Name))
+	}
+
+	createFileInContainer(app, w, r, "CreateChildFileFromTemplate", info.Id, template.Name, resp.Body, resp.ContentLength)
+}
+
+// canOpen checks if the WOPI client can open the file
+func (app *demoApp) canOpen(name string) bool {
+	for _, extensions := range app.appURLs {
+		if _, ok := extensions[path.Ext(name)]; ok {
+			return true
+		}
+	}
+	return false
+}
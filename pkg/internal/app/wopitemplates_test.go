@@ -0,0 +1,48 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/wkloucek/cs3-wopi-server/pkg/internal/templates"
+)
+
+func getFileRequest(t *testing.T, app *demoApp, wopiContext WopiContext, header map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, wopiURL(t, app, wopiContext, "/contents"), nil)
+	for key, value := range header {
+		req.Header.Set(key, value)
+	}
+	res := httptest.NewRecorder()
+	app.router().ServeHTTP(res, req)
+	return res
+}
+
+func TestGetFileServesTemplatesLikeFiles(t *testing.T) {
+	info := testFileInfo()
+	app := newTestApp(t, &fakeGateway{info: info})
+	app.templateStore = templates.New("")
+	wopiContext := newTestWopiContext(t, info)
+
+	template, err := app.templateStore.Template(".docx")
+	if err != nil || len(template) == 0 {
+		t.Fatalf("no built-in template for .docx: %v", err)
+	}
+
+	res := getFileRequest(t, app, wopiContext, nil)
+	if res.Code != http.StatusOK || res.Body.Len() != len(template) {
+		t.Fatalf("GetFile = %d with %d bytes, want 200 with the template", res.Code, res.Body.Len())
+	}
+
+	res = getFileRequest(t, app, wopiContext, map[string]string{"Range": "bytes=0-9"})
+	if res.Code != http.StatusPartialContent || res.Body.String() != string(template[:10]) {
+		t.Fatalf("GetFile with a range = %d with %d bytes, want 206 with the range of the template", res.Code, res.Body.Len())
+	}
+
+	res = getFileRequest(t, app, wopiContext, map[string]string{HeaderWopiMaxExpectedSize: "10"})
+	if res.Code != http.StatusPreconditionFailed {
+		t.Fatalf("GetFile with a smaller max expected size = %d, want 412", res.Code)
+	}
+}
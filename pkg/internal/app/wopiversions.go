@@ -0,0 +1,505 @@
+package app
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"time"
+
+	appproviderv1beta1 "github.com/cs3org/go-cs3apis/cs3/app/provider/v1beta1"
+	rpcv1beta1 "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
+	providerv1beta1 "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+)
+
+// the page behind the FileVersionUrl. It doesn't contain access tokens for the versions, they are minted when
+// a version is opened. The forms carry an anti-CSRF token, which is bound to the access token of the page.
+var fileVersionsTemplate = template.Must(template.New("versions").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Versions of {{ .Name }}</title>
+</head>
+<body>
+<h1>Versions of {{ .Name }}</h1>
+{{ if not .Versions }}<p>There are no previous versions of this file.</p>{{ end }}
+<table>
+{{ range .Versions }}
+<tr>
+<td>{{ .Modified }}</td>
+<td>{{ .Size }} bytes</td>
+<td>
+<form action="{{ $.OpenURL }}" method="post" target="_blank">
+<input type="hidden" name="key" value="{{ .Key }}">
+<input type="hidden" name="csrf_token" value="{{ $.CSRFToken }}">
+<button type="submit">Open</button>
+</form>
+</td>
+<td>
+{{ if $.CanRestore }}
+<form action="{{ $.RestoreURL }}" method="post">
+<input type="hidden" name="key" value="{{ .Key }}">
+<input type="hidden" name="csrf_token" value="{{ $.CSRFToken }}">
+<button type="submit">Restore</button>
+</form>
+{{ end }}
+</td>
+</tr>
+{{ end }}
+</table>
+</body>
+</html>
+`))
+
+// the response to opening a version, it posts the access token of the version to the office app
+var openFileVersionTemplate = template.Must(template.New("open").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{ .Name }}</title>
+</head>
+<body onload="document.forms[0].submit()">
+<form action="{{ .AppURL }}" method="post">
+<input type="hidden" name="access_token" value="{{ .AccessToken }}">
+<input type="hidden" name="access_token_ttl" value="{{ .AccessTokenTTL }}">
+<noscript><button type="submit">Open</button></noscript>
+</form>
+</body>
+</html>
+`))
+
+type fileVersionsPage struct {
+	Name       string
+	CanRestore bool
+	OpenURL    string
+	RestoreURL string
+	CSRFToken  string
+	Versions   []fileVersionsPageEntry
+}
+
+type fileVersionsPageEntry struct {
+	Key      string
+	Modified string
+	Size     uint64
+}
+
+type openFileVersionPage struct {
+	Name           string
+	AppURL         string
+	AccessToken    string
+	AccessTokenTTL string
+}
+
+// FileVersions renders a page listing the versions of the file, which can be opened read-only or restored
+func FileVersions(app *demoApp, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	wopiContext, _ := WopiContextFromCtx(ctx)
+
+	statRes, err := app.gwc.Stat(ctx, &providerv1beta1.StatRequest{
+		Ref: &wopiContext.FileReference,
+	})
+	if err != nil {
+		app.Logger.Error().Err(err).Str("FileReference", wopiContext.FileReference.String()).Msg("FileVersions: stat failed")
+		cs3Error(w, err)
+		return
+	}
+
+	switch statRes.Status.Code {
+	case rpcv1beta1.Code_CODE_OK:
+	case rpcv1beta1.Code_CODE_NOT_FOUND:
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	default:
+		app.Logger.Error().Str("status_code", statRes.Status.Code.String()).Str("status_msg", statRes.Status.Message).Str("FileReference", wopiContext.FileReference.String()).Msg("FileVersions: stat failed")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	versions, err := app.listFileVersions(ctx, &wopiContext.FileReference)
+	if err != nil {
+		app.Logger.Error().Err(err).Str("FileReference", wopiContext.FileReference.String()).Msg("FileVersions: listing the file versions failed")
+		cs3Error(w, err)
+		return
+	}
+
+	openURL := *r.URL
+	openURL.Path = path.Join(r.URL.Path, "open")
+
+	page := fileVersionsPage{
+		Name:       path.Base(statRes.Info.Path),
+		CanRestore: canRestoreFileVersion(wopiContext, statRes.Info),
+		OpenURL:    openURL.RequestURI(),
+		RestoreURL: r.URL.RequestURI(),
+		CSRFToken:  app.versionsCSRFToken(r.URL.Query().Get("access_token")),
+	}
+
+	for _, version := range versions {
+		page.Versions = append(page.Versions, fileVersionsPageEntry{
+			Key:      version.Key,
+			Modified: time.Unix(int64(version.Mtime), 0).UTC().Format(time.RFC1123),
+			Size:     version.Size,
+		})
+	}
+
+	setVersionsPageHeaders(w)
+	w.WriteHeader(http.StatusOK)
+	if err := fileVersionsTemplate.Execute(w, page); err != nil {
+		app.Logger.Error().Err(err).Str("FileReference", wopiContext.FileReference.String()).Msg("FileVersions: rendering the page failed")
+	}
+}
+
+// OpenFileVersion opens the version of the file with the key from the request form read-only in the office app.
+// The access token for the version is minted here and posted to the office app by the browser.
+func OpenFileVersion(app *demoApp, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	wopiContext, _ := WopiContextFromCtx(ctx)
+
+	if !app.isVersionsPageRequest(r) {
+		app.Logger.Warn().Str("FileReference", wopiContext.FileReference.String()).Msg("OpenFileVersion: cross-site request rejected")
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	key := r.PostFormValue("key")
+	if key == "" {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	statRes, err := app.gwc.Stat(ctx, &providerv1beta1.StatRequest{
+		Ref: &wopiContext.FileReference,
+	})
+	if err != nil {
+		app.Logger.Error().Err(err).Str("FileReference", wopiContext.FileReference.String()).Msg("OpenFileVersion: stat failed")
+		cs3Error(w, err)
+		return
+	}
+
+	switch statRes.Status.Code {
+	case rpcv1beta1.Code_CODE_OK:
+	case rpcv1beta1.Code_CODE_NOT_FOUND:
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	default:
+		app.Logger.Error().Str("status_code", statRes.Status.Code.String()).Str("status_msg", statRes.Status.Message).Str("FileReference", wopiContext.FileReference.String()).Msg("OpenFileVersion: stat failed")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	// only versions of the file can be opened
+	version, err := app.fileVersion(ctx, &wopiContext.FileReference, key)
+	if err != nil {
+		app.Logger.Error().Err(err).Str("FileReference", wopiContext.FileReference.String()).Str("version_key", key).Msg("OpenFileVersion: listing the file versions failed")
+		cs3Error(w, err)
+		return
+	}
+	if version == nil {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	appURL, accessToken, accessTokenExpiresAt, err := app.newVersionSession(wopiContext, statRes.Info, key)
+	if err != nil {
+		app.Logger.Error().Err(err).Str("FileReference", wopiContext.FileReference.String()).Str("version_key", key).Msg("OpenFileVersion: creating the access token failed")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	setVersionsPageHeaders(w)
+	w.WriteHeader(http.StatusOK)
+	if err := openFileVersionTemplate.Execute(w, openFileVersionPage{
+		Name:           path.Base(statRes.Info.Path),
+		AppURL:         appURL,
+		AccessToken:    accessToken,
+		AccessTokenTTL: strconv.FormatInt(accessTokenExpiresAt*1000, 10),
+	}); err != nil {
+		app.Logger.Error().Err(err).Str("FileReference", wopiContext.FileReference.String()).Msg("OpenFileVersion: rendering the page failed")
+	}
+}
+
+// RestoreFileVersion restores the version of the file with the key from the request form
+func RestoreFileVersion(app *demoApp, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	wopiContext, _ := WopiContextFromCtx(ctx)
+
+	if !app.isVersionsPageRequest(r) {
+		app.Logger.Warn().Str("FileReference", wopiContext.FileReference.String()).Msg("RestoreFileVersion: cross-site request rejected")
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	key := r.PostFormValue("key")
+	if key == "" {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	statRes, err := app.gwc.Stat(ctx, &providerv1beta1.StatRequest{
+		Ref: &wopiContext.FileReference,
+	})
+	if err != nil {
+		app.Logger.Error().Err(err).Str("FileReference", wopiContext.FileReference.String()).Msg("RestoreFileVersion: stat failed")
+		cs3Error(w, err)
+		return
+	}
+
+	switch statRes.Status.Code {
+	case rpcv1beta1.Code_CODE_OK:
+	case rpcv1beta1.Code_CODE_NOT_FOUND:
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	default:
+		app.Logger.Error().Str("status_code", statRes.Status.Code.String()).Str("status_msg", statRes.Status.Message).Str("FileReference", wopiContext.FileReference.String()).Msg("RestoreFileVersion: stat failed")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	if !canRestoreFileVersion(wopiContext, statRes.Info) {
+		notAuthorized(w)
+		return
+	}
+
+	// the file is usually locked by the editor session the restore is started from,
+	// locks of other sessions prevent the restore
+	lock, err := app.lockStore.GetLock(ctx, &wopiContext.FileReference)
+	if err != nil {
+		app.Logger.Error().Err(err).Str("FileReference", wopiContext.FileReference.String()).Msg("RestoreFileVersion: GetLock failed")
+		cs3Error(w, err)
+		return
+	}
+
+	storageLockID := ""
+	if lock != nil {
+		if !app.isWopiLock(lock) {
+			app.Logger.Warn().Str("current_lock_id", lock.LockId).Str("FileReference", wopiContext.FileReference.String()).Msg("RestoreFileVersion: file is locked by another app")
+			lockConflict(w, lock, "File locked by another app")
+			return
+		}
+		if !app.lockSessions.holds(wopiContext, lock) {
+			app.Logger.Warn().Str("current_lock_id", lock.LockId).Str("FileReference", wopiContext.FileReference.String()).Msg("RestoreFileVersion: file is locked by another session")
+			lockConflict(w, lock, "File locked by another session")
+			return
+		}
+		storageLockID = app.lockStore.StorageLockID(ctx, &wopiContext.FileReference, lock.LockId)
+	}
+
+	restoreRes, err := app.gwc.RestoreFileVersion(ctx, &providerv1beta1.RestoreFileVersionRequest{
+		Ref:    &wopiContext.FileReference,
+		Key:    key,
+		LockId: storageLockID,
+	})
+	if err != nil {
+		app.Logger.Error().Err(err).Str("FileReference", wopiContext.FileReference.String()).Str("version_key", key).Msg("RestoreFileVersion: restore failed")
+		cs3Error(w, err)
+		return
+	}
+
+	switch restoreRes.Status.Code {
+	case rpcv1beta1.Code_CODE_OK:
+		// show the versions page again
+		http.Redirect(w, r, r.URL.RequestURI(), http.StatusSeeOther)
+		return
+
+	case rpcv1beta1.Code_CODE_NOT_FOUND:
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+
+	case rpcv1beta1.Code_CODE_PERMISSION_DENIED:
+		app.Logger.Warn().Str("status_msg", restoreRes.Status.Message).Str("FileReference", wopiContext.FileReference.String()).Msg("RestoreFileVersion: restore not permitted")
+		notAuthorized(w)
+		return
+
+	case rpcv1beta1.Code_CODE_LOCKED, rpcv1beta1.Code_CODE_ABORTED, rpcv1beta1.Code_CODE_FAILED_PRECONDITION:
+		// the file got locked in the meantime
+		storageLockConflict(app, w, r, "RestoreFileVersion")
+		return
+
+	default:
+		app.Logger.Error().Str("status_code", restoreRes.Status.Code.String()).Str("status_msg", restoreRes.Status.Message).Str("FileReference", wopiContext.FileReference.String()).Str("version_key", key).Msg("RestoreFileVersion: restore failed")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+}
+
+// VersionReadOnlyMiddleware prevents modifications through wopi contexts of file versions,
+// only reading the lock and storing the UserInfo is allowed
+func VersionReadOnlyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		wopiContext, _ := WopiContextFromCtx(r.Context())
+
+		if wopiContext.VersionKey != "" && r.Method != http.MethodGet {
+			switch r.Header.Get("X-WOPI-Override") {
+			case "GET_LOCK", "PUT_USER_INFO":
+			default:
+				notAuthorized(w)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// listFileVersions returns the previous versions of the file, the newest version first
+func (app *demoApp) listFileVersions(ctx context.Context, ref *providerv1beta1.Reference) ([]*providerv1beta1.FileVersion, error) {
+	listRes, err := app.gwc.ListFileVersions(ctx, &providerv1beta1.ListFileVersionsRequest{
+		Ref: ref,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if listRes.Status.Code != rpcv1beta1.Code_CODE_OK {
+		return nil, fmt.Errorf("list file versions failed with status code %s: %s", listRes.Status.Code.String(), listRes.Status.Message)
+	}
+
+	versions := listRes.Versions
+	sort.SliceStable(versions, func(i, j int) bool {
+		return versions[i].Mtime > versions[j].Mtime
+	})
+
+	return versions, nil
+}
+
+// fileVersion returns the version of the file with the key or nil if there is no such version
+func (app *demoApp) fileVersion(ctx context.Context, ref *providerv1beta1.Reference, key string) (*providerv1beta1.FileVersion, error) {
+	versions, err := app.listFileVersions(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, version := range versions {
+		if version.Key == key {
+			return version, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// newVersionSession mints an access token to open a version of the file read-only in the office app.
+// It returns the app url, the access token and its expiration time.
+func (app *demoApp) newVersionSession(wopiContext WopiContext, info *providerv1beta1.ResourceInfo, key string) (string, string, int64, error) {
+	// every version gets its own WOPISrc, so that the office app doesn't mix it up with the current file
+	versionRef := fileRefFromResourceID(versionResourceID(info.Id, key))
+
+	viewAppURL, _, err := app.appURLsForFile(path.Ext(info.Path), versionRef)
+	if err != nil {
+		return "", "", 0, err
+	}
+
+	versionWopiContext := wopiContext
+	versionWopiContext.FileReference = providerv1beta1.Reference{
+		ResourceId: info.Id,
+		Path:       ".",
+	}
+	versionWopiContext.VersionKey = key
+	versionWopiContext.Scope = WopiContextScopeFile
+	versionWopiContext.ViewAppUrl = viewAppURL
+	versionWopiContext.EditAppUrl = viewAppURL
+	if versionWopiContext.ViewMode != appproviderv1beta1.OpenInAppRequest_VIEW_MODE_VIEW_ONLY {
+		versionWopiContext.ViewMode = appproviderv1beta1.OpenInAppRequest_VIEW_MODE_READ_ONLY
+	}
+
+	accessToken, accessTokenExpiresAt, err := app.newAccessToken(versionWopiContext)
+	if err != nil {
+		return "", "", 0, err
+	}
+
+	return viewAppURL, accessToken, accessTokenExpiresAt, nil
+}
+
+// fileVersionsURL returns the url of the versions page for the file of the wopi context. The access token
+// of the url is scoped to the versions page, it can't be used for the WOPI operations on the file.
+func (app *demoApp) fileVersionsURL(wopiContext WopiContext) (string, error) {
+	versionsWopiContext := wopiContext
+	versionsWopiContext.Scope = WopiContextScopeVersions
+
+	accessToken, _, err := app.newAccessToken(versionsWopiContext)
+	if err != nil {
+		return "", err
+	}
+
+	versionsURL := app.wopiSrcURL(fileRefFromResourceID(wopiContext.FileReference.ResourceId))
+	versionsURL.Path = path.Join(versionsURL.Path, "versions")
+	versionsURL.RawQuery = url.Values{"access_token": []string{accessToken}}.Encode()
+
+	return versionsURL.String(), nil
+}
+
+// versionsCSRFToken returns the anti-CSRF token of the versions page with the access token
+func (app *demoApp) versionsCSRFToken(accessToken string) string {
+	mac := hmac.New(sha256.New, []byte(app.Config.WopiSecret))
+	mac.Write([]byte("versions-page\x00" + accessToken))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// isVersionsPageRequest checks that a form of the versions page was submitted from the versions page itself.
+// Browsers tell the origin of the page, which must be the WOPI server, and the anti-CSRF token must match.
+func (app *demoApp) isVersionsPageRequest(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		origin = urlOrigin(r.Header.Get("Referer"))
+	}
+	if origin != "" && origin != app.Config.HTTP.Scheme+"://"+app.Config.HTTP.Addr {
+		return false
+	}
+
+	csrfToken := r.PostFormValue("csrf_token")
+	expected := app.versionsCSRFToken(r.URL.Query().Get("access_token"))
+	return csrfToken != "" && hmac.Equal([]byte(csrfToken), []byte(expected))
+}
+
+// urlOrigin returns the origin of the url, eg. https://ocis.owncloud.test, or an empty string if it is invalid
+func urlOrigin(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return ""
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+// setVersionsPageHeaders prevents that the pages with access tokens in their url are cached or leak the
+// url to the office app as referrer
+func setVersionsPageHeaders(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "same-origin")
+}
+
+// contentReference returns the reference to download the content of the wopi context, which is
+// either the current file or one of its versions
+func contentReference(wopiContext WopiContext) *providerv1beta1.Reference {
+	if wopiContext.VersionKey == "" {
+		return &wopiContext.FileReference
+	}
+
+	// the storage resolves versions by their key in the opaque id
+	return &providerv1beta1.Reference{
+		ResourceId: versionResourceID(wopiContext.FileReference.ResourceId, wopiContext.VersionKey),
+		Path:       ".",
+	}
+}
+
+// versionResourceID returns the resource id of a file version
+func versionResourceID(id *providerv1beta1.ResourceId, key string) *providerv1beta1.ResourceId {
+	return &providerv1beta1.ResourceId{
+		StorageId: id.GetStorageId(),
+		SpaceId:   id.GetSpaceId(),
+		OpaqueId:  key,
+	}
+}
+
+// canRestoreFileVersion checks if the user can restore versions of the file
+func canRestoreFileVersion(wopiContext WopiContext, info *providerv1beta1.ResourceInfo) bool {
+	return wopiContext.VersionKey == "" &&
+		wopiContext.ViewMode == appproviderv1beta1.OpenInAppRequest_VIEW_MODE_READ_WRITE &&
+		info.PermissionSet.GetRestoreFileVersion()
+}
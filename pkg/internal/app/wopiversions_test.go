@@ -0,0 +1,227 @@
+package app
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	rpcv1beta1 "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
+	providerv1beta1 "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	"github.com/golang-jwt/jwt"
+)
+
+var csrfTokenField = regexp.MustCompile(`name="csrf_token" value="([^"]+)"`)
+
+// getVersionsPage returns the versions page and its anti-CSRF token
+func getVersionsPage(t *testing.T, handler http.Handler, versionsURL string) (string, string) {
+	t.Helper()
+
+	res := httptest.NewRecorder()
+	handler.ServeHTTP(res, httptest.NewRequest(http.MethodGet, versionsURL, nil))
+	if res.Code != http.StatusOK {
+		t.Fatalf("GET versions page = %d, want 200", res.Code)
+	}
+
+	body := res.Body.String()
+	match := csrfTokenField.FindStringSubmatch(body)
+	if match == nil {
+		t.Fatalf("versions page has no anti-CSRF token: %s", body)
+	}
+	return body, match[1]
+}
+
+func postVersionsForm(handler http.Handler, formURL string, form url.Values, origin string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, formURL, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if origin != "" {
+		req.Header.Set("Origin", origin)
+	}
+	res := httptest.NewRecorder()
+	handler.ServeHTTP(res, req)
+	return res
+}
+
+func newVersionsTestApp(t *testing.T) (*demoApp, *fakeGateway, string) {
+	t.Helper()
+
+	info := testFileInfo()
+	gwc := &fakeGateway{
+		info: info,
+		versions: []*providerv1beta1.FileVersion{
+			{Key: "v1", Mtime: 1700000000, Size: 42},
+		},
+	}
+	app := newTestApp(t, gwc)
+
+	versionsURL, err := app.fileVersionsURL(newTestWopiContext(t, info))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return app, gwc, versionsURL
+}
+
+func TestFileVersionsPageHasNoAccessTokens(t *testing.T) {
+	app, _, versionsURL := newVersionsTestApp(t)
+
+	body, _ := getVersionsPage(t, app.router(), versionsURL)
+	if strings.Contains(body, `name="access_token"`) {
+		t.Fatalf("versions page contains access tokens: %s", body)
+	}
+	if !strings.Contains(body, `name="key" value="v1"`) {
+		t.Fatalf("versions page doesn't list the version: %s", body)
+	}
+}
+
+func TestFileVersionsAccessTokenScope(t *testing.T) {
+	app, _, versionsURL := newVersionsTestApp(t)
+	handler := app.router()
+
+	// the access token of the versions page can't be used for WOPI operations on the file
+	checkFileInfoURL := strings.Replace(versionsURL, "/versions?", "?", 1)
+	res := httptest.NewRecorder()
+	handler.ServeHTTP(res, httptest.NewRequest(http.MethodGet, checkFileInfoURL, nil))
+	if res.Code != http.StatusUnauthorized {
+		t.Fatalf("CheckFileInfo with the access token of the versions page = %d, want 401", res.Code)
+	}
+
+	// and file access tokens can't be used for the versions page
+	fileVersionsURL := wopiURL(t, app, newTestWopiContext(t, testFileInfo()), "/versions")
+	res = httptest.NewRecorder()
+	handler.ServeHTTP(res, httptest.NewRequest(http.MethodGet, fileVersionsURL, nil))
+	if res.Code != http.StatusUnauthorized {
+		t.Fatalf("versions page with a file access token = %d, want 401", res.Code)
+	}
+}
+
+func TestOpenFileVersionMintsAccessToken(t *testing.T) {
+	app, _, versionsURL := newVersionsTestApp(t)
+	handler := app.router()
+	_, csrfToken := getVersionsPage(t, handler, versionsURL)
+
+	openURL := strings.Replace(versionsURL, "/versions?", "/versions/open?", 1)
+	res := postVersionsForm(handler, openURL, url.Values{"key": {"v1"}, "csrf_token": {csrfToken}}, "https://wopi.example.com")
+	if res.Code != http.StatusOK {
+		t.Fatalf("open version = %d, want 200", res.Code)
+	}
+	body, _ := io.ReadAll(res.Body)
+	if !strings.Contains(string(body), `action="https://office.example.com/view?`) || !strings.Contains(string(body), `name="access_token"`) {
+		t.Fatalf("open version doesn't post an access token to the office app: %s", body)
+	}
+	if res.Header().Get("Cache-Control") != "no-store" {
+		t.Fatalf("the response with the access token may be cached")
+	}
+
+	// unknown versions can't be opened
+	res = postVersionsForm(handler, openURL, url.Values{"key": {"v2"}, "csrf_token": {csrfToken}}, "")
+	if res.Code != http.StatusNotFound {
+		t.Fatalf("open unknown version = %d, want 404", res.Code)
+	}
+}
+
+func TestRestoreFileVersionRejectsCrossSiteRequests(t *testing.T) {
+	app, gwc, versionsURL := newVersionsTestApp(t)
+	handler := app.router()
+	_, csrfToken := getVersionsPage(t, handler, versionsURL)
+
+	tests := []struct {
+		name   string
+		form   url.Values
+		origin string
+		want   int
+	}{
+		{"missing anti-CSRF token", url.Values{"key": {"v1"}}, "", http.StatusForbidden},
+		{"wrong anti-CSRF token", url.Values{"key": {"v1"}, "csrf_token": {"forged"}}, "", http.StatusForbidden},
+		{"foreign origin", url.Values{"key": {"v1"}, "csrf_token": {csrfToken}}, "https://evil.example.com", http.StatusForbidden},
+		{"same origin", url.Values{"key": {"v1"}, "csrf_token": {csrfToken}}, "https://wopi.example.com", http.StatusSeeOther},
+	}
+	for _, tt := range tests {
+		res := postVersionsForm(handler, versionsURL, tt.form, tt.origin)
+		if res.Code != tt.want {
+			t.Errorf("%s: restore = %d, want %d", tt.name, res.Code, tt.want)
+		}
+	}
+
+	if len(gwc.restored) != 1 || gwc.restored[0] != "v1" {
+		t.Fatalf("restored versions = %v, want only the same origin request", gwc.restored)
+	}
+}
+
+func TestRestoreFileVersionLockedFile(t *testing.T) {
+	app, gwc, _ := newVersionsTestApp(t)
+	handler := app.router()
+
+	// the file is locked by the editor session of another user
+	otherWopiContext := newTestWopiContext(t, testFileInfo())
+	otherCS3Token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.StandardClaims{
+		ExpiresAt: time.Now().Add(time.Hour).Unix(),
+		Subject:   "another-session",
+	}).SignedString([]byte("cs3-secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherWopiContext.AccessToken = otherCS3Token
+	req := httptest.NewRequest(http.MethodPost, wopiURL(t, app, otherWopiContext, ""), nil)
+	req.Header.Set("X-WOPI-Override", "LOCK")
+	req.Header.Set(HeaderWopiLock, "other-lock")
+	res := httptest.NewRecorder()
+	handler.ServeHTTP(res, req)
+	if res.Code != http.StatusOK {
+		t.Fatalf("LOCK = %d, want 200", res.Code)
+	}
+
+	wopiContext := newTestWopiContext(t, testFileInfo())
+	versionsURL, err := app.fileVersionsURL(wopiContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, csrfToken := getVersionsPage(t, handler, versionsURL)
+	form := url.Values{"key": {"v1"}, "csrf_token": {csrfToken}}
+
+	res = postVersionsForm(handler, versionsURL, form, "https://wopi.example.com")
+	if res.Code != http.StatusConflict || res.Header().Get(HeaderWopiLock) != "other-lock" {
+		t.Fatalf("restore of a file locked by another session = %d with lock %q, want 409 with the lock", res.Code, res.Header().Get(HeaderWopiLock))
+	}
+	if len(gwc.restored) != 0 {
+		t.Fatalf("restored versions = %v, want none", gwc.restored)
+	}
+
+	// the editor session of the versions page takes over the lock
+	req = httptest.NewRequest(http.MethodPost, wopiURL(t, app, wopiContext, ""), nil)
+	req.Header.Set("X-WOPI-Override", "LOCK")
+	req.Header.Set(HeaderWopiLock, "own-lock")
+	req.Header.Set(HeaderWopiOldLock, "other-lock")
+	res = httptest.NewRecorder()
+	handler.ServeHTTP(res, req)
+	if res.Code != http.StatusOK {
+		t.Fatalf("UNLOCK_AND_RELOCK = %d, want 200", res.Code)
+	}
+
+	res = postVersionsForm(handler, versionsURL, form, "https://wopi.example.com")
+	if res.Code != http.StatusSeeOther {
+		t.Fatalf("restore of a file locked by the own session = %d, want 303", res.Code)
+	}
+	if len(gwc.restored) != 1 || gwc.restored[0] != "v1" {
+		t.Fatalf("restored versions = %v, want v1", gwc.restored)
+	}
+}
+
+func TestRestoreFileVersionLockedInTheStorage(t *testing.T) {
+	app, gwc, versionsURL := newVersionsTestApp(t)
+	gwc.restoreStatus = rpcv1beta1.Code_CODE_LOCKED
+	handler := app.router()
+	_, csrfToken := getVersionsPage(t, handler, versionsURL)
+
+	// the lock isn't known to the lock store, only the reason is returned
+	res := postVersionsForm(handler, versionsURL, url.Values{"key": {"v1"}, "csrf_token": {csrfToken}}, "https://wopi.example.com")
+	if res.Code != http.StatusConflict {
+		t.Fatalf("restore of a file locked in the storage = %d, want 409", res.Code)
+	}
+	if res.Header().Get(HeaderWopiLockFailureReason) == "" {
+		t.Fatal("restore of a file locked in the storage has no lock failure reason")
+	}
+}
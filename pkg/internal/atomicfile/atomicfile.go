@@ -0,0 +1,39 @@
+// Package atomicfile reads and writes the files the local stores persist their state in.
+// The files are replaced atomically, so that a crash never leaves a half written file behind.
+package atomicfile
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// ReadFile returns the content of the file, a missing file is returned as empty content
+func ReadFile(file string) ([]byte, error) {
+	content, err := os.ReadFile(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	return content, err
+}
+
+// WriteFile replaces the content of the file. The content is written to a temporary file
+// in the same directory first, which is then renamed to the file.
+func WriteFile(file string, content []byte) error {
+	tmpFile, err := os.CreateTemp(filepath.Dir(file), filepath.Base(file)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+
+	if _, err := tmpFile.Write(content); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile.Name(), file)
+}
@@ -0,0 +1,46 @@
+package atomicfile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadFileMissing(t *testing.T) {
+	content, err := ReadFile(filepath.Join(t.TempDir(), "missing.json"))
+	if err != nil || content != nil {
+		t.Fatalf("ReadFile of a missing file = %q, %v, want nil, nil", content, err)
+	}
+}
+
+func TestWriteFile(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "store.json")
+
+	if err := WriteFile(file, []byte(`{"a":"1"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFile(file, []byte(`{"b":"2"}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := ReadFile(file)
+	if err != nil || string(content) != `{"b":"2"}` {
+		t.Fatalf("ReadFile = %q, %v, want the last written content", content, err)
+	}
+
+	// the temporary files are removed
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("directory contains %d files, want only the written file", len(entries))
+	}
+}
+
+func TestWriteFileMissingDirectory(t *testing.T) {
+	if err := WriteFile(filepath.Join(t.TempDir(), "missing", "store.json"), []byte("{}")); err == nil {
+		t.Fatal("WriteFile into a missing directory succeeded")
+	}
+}
@@ -1,9 +1,7 @@
 package helpers
 
 import (
-	"bytes"
 	"context"
-	"crypto/tls"
 	"errors"
 	"net/http"
 
@@ -19,7 +17,8 @@ func DownloadFile(
 	ref *providerv1beta1.Reference,
 	gwc gatewayv1beta1.GatewayAPIClient,
 	token string,
-	insecure bool,
+	byteRange string,
+	httpClient *http.Client,
 	logger log.Logger,
 ) (http.Response, error) {
 
@@ -62,15 +61,7 @@ func DownloadFile(
 		return http.Response{}, errors.New("download endpoint is missing")
 	}
 
-	httpClient := http.Client{
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{
-				InsecureSkipVerify: insecure,
-			},
-		},
-	}
-
-	httpReq, err := http.NewRequest(http.MethodGet, downloadEndpoint, bytes.NewReader([]byte("")))
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, downloadEndpoint, nil)
 	if err != nil {
 		return http.Response{}, err
 	}
@@ -80,6 +71,10 @@ func DownloadFile(
 	}
 	// TODO: the access token shouldn't be needed
 	httpReq.Header.Add("x-access-token", token)
+	if byteRange != "" {
+		// partial downloads, eg. to resume an interrupted download
+		httpReq.Header.Add("Range", byteRange)
+	}
 
 	httpResp, err := httpClient.Do(httpReq)
 	if err != nil {
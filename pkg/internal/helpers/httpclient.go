@@ -0,0 +1,63 @@
+package helpers
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/wkloucek/cs3-wopi-server/pkg/internal/resilience"
+)
+
+// HTTPClientOptions configures the HTTP client for the data gateway
+type HTTPClientOptions struct {
+	// skip the verification of the data gateway certificate
+	Insecure bool
+	// the maximum time to establish a TCP connection
+	DialTimeout time.Duration
+	// the maximum time for the TLS handshake
+	TLSHandshakeTimeout time.Duration
+	// the maximum time to wait for the response headers after the request was sent, 0 means no limit
+	ResponseHeaderTimeout time.Duration
+	// how long idle connections are kept open for reuse
+	IdleConnTimeout time.Duration
+	// the maximum number of idle connections, 0 means no limit
+	MaxIdleConns int
+	// the maximum number of idle connections per data gateway host
+	MaxIdleConnsPerHost int
+	// the maximum number of connections per data gateway host, 0 means no limit
+	MaxConnsPerHost int
+	// retries idempotent requests and fails fast while the data gateway is down, nil disables it
+	Policy *resilience.Policy
+}
+
+// NewHTTPClient returns a long-lived HTTP client for the data gateway, which reuses connections
+// for downloads and uploads. No overall timeout is set, because file transfers can take long.
+func NewHTTPClient(opts HTTPClientOptions) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   opts.DialTimeout,
+		KeepAlive: 30 * time.Second,
+	}
+
+	var transport http.RoundTripper = &http.Transport{
+		Proxy:       http.ProxyFromEnvironment,
+		DialContext: dialer.DialContext,
+		TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: opts.Insecure,
+		},
+		TLSHandshakeTimeout:   opts.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: opts.ResponseHeaderTimeout,
+		IdleConnTimeout:       opts.IdleConnTimeout,
+		MaxIdleConns:          opts.MaxIdleConns,
+		MaxIdleConnsPerHost:   opts.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       opts.MaxConnsPerHost,
+		ForceAttemptHTTP2:     true,
+	}
+	if opts.Policy != nil {
+		transport = resilience.NewTransport(transport, opts.Policy)
+	}
+
+	return &http.Client{
+		Transport: transport,
+	}
+}
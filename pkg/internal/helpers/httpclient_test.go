@@ -0,0 +1,64 @@
+package helpers
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func benchmarkClientOptions() HTTPClientOptions {
+	return HTTPClientOptions{
+		// the test server has a self-signed certificate
+		Insecure:            true,
+		DialTimeout:         5 * time.Second,
+		TLSHandshakeTimeout: 5 * time.Second,
+		IdleConnTimeout:     time.Minute,
+		MaxIdleConnsPerHost: 10,
+	}
+}
+
+func newBenchmarkServer(b *testing.B) *httptest.Server {
+	b.Helper()
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("content"))
+	}))
+	b.Cleanup(server.Close)
+	return server
+}
+
+func benchmarkGet(b *testing.B, httpClient *http.Client, url string) {
+	res, err := httpClient.Get(url)
+	if err != nil {
+		b.Fatal(err)
+	}
+	// the body needs to be read and closed to reuse the connection
+	_, _ = io.Copy(io.Discard, res.Body)
+	res.Body.Close()
+}
+
+// BenchmarkSharedHTTPClient reuses the TLS connections of a single client, like the app does
+func BenchmarkSharedHTTPClient(b *testing.B) {
+	server := newBenchmarkServer(b)
+	httpClient := NewHTTPClient(benchmarkClientOptions())
+	defer httpClient.CloseIdleConnections()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		benchmarkGet(b, httpClient, server.URL)
+	}
+}
+
+// BenchmarkPerCallHTTPClient creates a client for every request, which needs a new TLS handshake every time
+func BenchmarkPerCallHTTPClient(b *testing.B) {
+	server := newBenchmarkServer(b)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		httpClient := NewHTTPClient(benchmarkClientOptions())
+		benchmarkGet(b, httpClient, server.URL)
+		httpClient.CloseIdleConnections()
+	}
+}
@@ -0,0 +1,165 @@
+package helpers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/owncloud/ocis/v2/ocis-pkg/log"
+	"github.com/wkloucek/cs3-wopi-server/pkg/internal/resilience"
+)
+
+const (
+	// the TUS protocol version we speak
+	tusVersion string = "1.0.0"
+	// how often a failed chunk is sent again before the upload fails
+	tusChunkRetries int = 3
+)
+
+var (
+	// the size of the chunks that are sent with one PATCH request
+	tusChunkSize int64 = 8 * 1024 * 1024
+	// the delay before the first retry of a chunk, it doubles for every retry
+	tusRetryDelay time.Duration = 500 * time.Millisecond
+)
+
+// tusUpload sends the content in chunks to an upload that was already created by InitiateFileUpload.
+// Failed chunks are sent again from the offset the data gateway reports.
+func tusUpload(
+	ctx context.Context,
+	httpClient *http.Client,
+	uploadEndpoint string,
+	uploadToken string,
+	token string,
+	content io.Reader,
+	size int64,
+	logger log.Logger,
+) error {
+	chunk := make([]byte, min(tusChunkSize, max(size, 1)))
+
+	var offset int64
+	for offset < size {
+		// the chunk is kept in memory, so that it can be sent again
+		n, err := io.ReadFull(content, chunk[:min(int64(len(chunk)), size-offset)])
+		if err != nil {
+			return fmt.Errorf("reading the content at offset %d failed: %w", offset, err)
+		}
+		chunkStart := offset
+		chunkEnd := offset + int64(n)
+
+		retries := 0
+		delay := tusRetryDelay
+		for offset < chunkEnd {
+			newOffset, err := tusPatch(ctx, httpClient, uploadEndpoint, uploadToken, token, chunk[offset-chunkStart:n], offset)
+			if err == nil {
+				if newOffset <= offset || newOffset > chunkEnd {
+					return fmt.Errorf("unexpected upload offset %d after sending the chunk at offset %d", newOffset, offset)
+				}
+				// the data gateway may only take a part of the chunk, the rest is sent with the next request
+				offset = newOffset
+				continue
+			}
+
+			if retries >= tusChunkRetries || errors.Is(err, ErrPreconditionFailed) || errors.Is(err, resilience.ErrCircuitOpen) {
+				return err
+			}
+			retries++
+			logger.Warn().Err(err).Int64("offset", offset).Int("retry", retries).Msg("UploadHelper: TUS chunk upload failed, retrying")
+
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(delay):
+			}
+			delay *= 2
+
+			// resume at the offset the data gateway has stored
+			newOffset, err = tusOffset(ctx, httpClient, uploadEndpoint, uploadToken, token)
+			if err != nil {
+				logger.Warn().Err(err).Int64("offset", offset).Msg("UploadHelper: getting the TUS upload offset failed")
+				continue
+			}
+			if newOffset < chunkStart || newOffset > chunkEnd {
+				return fmt.Errorf("unexpected upload offset %d, expected an offset between %d and %d", newOffset, chunkStart, chunkEnd)
+			}
+			offset = newOffset
+		}
+	}
+
+	return nil
+}
+
+// tusPatch sends data at the offset and returns the new offset of the upload
+func tusPatch(ctx context.Context, httpClient *http.Client, uploadEndpoint string, uploadToken string, token string, data []byte, offset int64) (int64, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPatch, uploadEndpoint, bytes.NewReader(data))
+	if err != nil {
+		return offset, err
+	}
+	addTusHeaders(httpReq, uploadToken, token)
+	httpReq.Header.Set("Content-Type", "application/offset+octet-stream")
+	httpReq.Header.Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	httpReq.ContentLength = int64(len(data))
+
+	httpResp, err := httpClient.Do(httpReq)
+	if err != nil {
+		return offset, err
+	}
+	defer httpResp.Body.Close()
+
+	if httpResp.StatusCode == http.StatusPreconditionFailed {
+		// the file was modified since the etag passed as ifMatch, sending the chunk again won't help
+		return offset, ErrPreconditionFailed
+	}
+
+	if httpResp.StatusCode != http.StatusNoContent && httpResp.StatusCode != http.StatusOK {
+		return offset, fmt.Errorf("TUS PATCH returned status code %d", httpResp.StatusCode)
+	}
+
+	newOffset, err := strconv.ParseInt(httpResp.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		return offset, errors.New("TUS PATCH response has no valid Upload-Offset")
+	}
+
+	return newOffset, nil
+}
+
+// tusOffset returns the offset the data gateway has stored for the upload
+func tusOffset(ctx context.Context, httpClient *http.Client, uploadEndpoint string, uploadToken string, token string) (int64, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodHead, uploadEndpoint, nil)
+	if err != nil {
+		return 0, err
+	}
+	addTusHeaders(httpReq, uploadToken, token)
+
+	httpResp, err := httpClient.Do(httpReq)
+	if err != nil {
+		return 0, err
+	}
+	defer httpResp.Body.Close()
+
+	if httpResp.StatusCode != http.StatusOK && httpResp.StatusCode != http.StatusNoContent {
+		return 0, fmt.Errorf("TUS HEAD returned status code %d", httpResp.StatusCode)
+	}
+
+	offset, err := strconv.ParseInt(httpResp.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		return 0, errors.New("TUS HEAD response has no valid Upload-Offset")
+	}
+
+	return offset, nil
+}
+
+func addTusHeaders(httpReq *http.Request, uploadToken string, token string) {
+	httpReq.Header.Set("Tus-Resumable", tusVersion)
+	if uploadToken != "" {
+		// public link uploads have the token in the upload endpoint
+		httpReq.Header.Add("X-Reva-Transfer", uploadToken)
+	}
+	// TODO: the access token shouldn't be needed
+	httpReq.Header.Add("x-access-token", token)
+}
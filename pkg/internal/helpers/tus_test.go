@@ -0,0 +1,188 @@
+package helpers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/owncloud/ocis/v2/ocis-pkg/log"
+)
+
+// fakeTusServer is a data gateway that stores a single TUS upload
+type fakeTusServer struct {
+	mu sync.Mutex
+
+	data []byte
+	// the maximum number of bytes taken from a PATCH request, 0 means no limit
+	maxPatchSize int
+	// the number of PATCH requests that fail after taking their data
+	failPatches int
+	// the status code of all PATCH requests, 0 means they are handled
+	patchStatus int
+
+	patchOffsets []int64
+	heads        int
+}
+
+func (s *fakeTusServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r.Header.Get("Tus-Resumable") != tusVersion || r.Header.Get("x-access-token") != "token" {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodHead:
+		s.heads++
+		w.Header().Set("Upload-Offset", strconv.Itoa(len(s.data)))
+		w.WriteHeader(http.StatusOK)
+	case http.MethodPatch:
+		offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+		if err != nil || offset != int64(len(s.data)) {
+			http.Error(w, http.StatusText(http.StatusConflict), http.StatusConflict)
+			return
+		}
+		s.patchOffsets = append(s.patchOffsets, offset)
+		if s.patchStatus != 0 {
+			http.Error(w, http.StatusText(s.patchStatus), s.patchStatus)
+			return
+		}
+
+		body, _ := io.ReadAll(r.Body)
+		if s.maxPatchSize > 0 && len(body) > s.maxPatchSize {
+			body = body[:s.maxPatchSize]
+		}
+		s.data = append(s.data, body...)
+
+		if s.failPatches > 0 {
+			// the data was stored, but the response gets lost
+			s.failPatches--
+			http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Upload-Offset", strconv.Itoa(len(s.data)))
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+// setTusTestSizes uses small chunks and short retry delays for the test
+func setTusTestSizes(t *testing.T, chunkSize int64) {
+	t.Helper()
+
+	oldChunkSize, oldRetryDelay := tusChunkSize, tusRetryDelay
+	tusChunkSize, tusRetryDelay = chunkSize, time.Millisecond
+	t.Cleanup(func() {
+		tusChunkSize, tusRetryDelay = oldChunkSize, oldRetryDelay
+	})
+}
+
+func testContent(size int) []byte {
+	content := make([]byte, size)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+	return content
+}
+
+func runTusUpload(t *testing.T, fake *fakeTusServer, content []byte) error {
+	t.Helper()
+
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	return tusUpload(context.Background(), server.Client(), server.URL, "", "token", bytes.NewReader(content), int64(len(content)), log.NopLogger())
+}
+
+func TestTusUploadSendsChunks(t *testing.T) {
+	setTusTestSizes(t, 10)
+	fake := &fakeTusServer{}
+	content := testContent(25)
+
+	if err := runTusUpload(t, fake, content); err != nil {
+		t.Fatalf("tusUpload failed: %v", err)
+	}
+	if !bytes.Equal(fake.data, content) {
+		t.Fatalf("uploaded %d bytes, want the %d bytes of the content", len(fake.data), len(content))
+	}
+	want := []int64{0, 10, 20}
+	if !slices.Equal(fake.patchOffsets, want) {
+		t.Fatalf("PATCH offsets = %v, want %v", fake.patchOffsets, want)
+	}
+}
+
+func TestTusUploadContinuesPartialChunks(t *testing.T) {
+	setTusTestSizes(t, 10)
+	// the data gateway only takes a part of each request
+	fake := &fakeTusServer{maxPatchSize: 4}
+	content := testContent(15)
+
+	if err := runTusUpload(t, fake, content); err != nil {
+		t.Fatalf("tusUpload failed: %v", err)
+	}
+	if !bytes.Equal(fake.data, content) {
+		t.Fatalf("uploaded %d bytes, want the %d bytes of the content", len(fake.data), len(content))
+	}
+	want := []int64{0, 4, 8, 10, 14}
+	if !slices.Equal(fake.patchOffsets, want) {
+		t.Fatalf("PATCH offsets = %v, want %v", fake.patchOffsets, want)
+	}
+}
+
+func TestTusUploadResumesAfterFailedPatch(t *testing.T) {
+	setTusTestSizes(t, 10)
+	// the first PATCH stores a part of the chunk, but fails
+	fake := &fakeTusServer{maxPatchSize: 6, failPatches: 1}
+	content := testContent(10)
+
+	if err := runTusUpload(t, fake, content); err != nil {
+		t.Fatalf("tusUpload failed: %v", err)
+	}
+	if !bytes.Equal(fake.data, content) {
+		t.Fatalf("uploaded %d bytes, want the %d bytes of the content", len(fake.data), len(content))
+	}
+	if fake.heads != 1 {
+		t.Fatalf("HEAD was requested %d times, want once after the failed PATCH", fake.heads)
+	}
+	// the upload resumes at the offset of the HEAD response instead of sending the whole chunk again
+	want := []int64{0, 6}
+	if !slices.Equal(fake.patchOffsets, want) {
+		t.Fatalf("PATCH offsets = %v, want %v", fake.patchOffsets, want)
+	}
+}
+
+func TestTusUploadGivesUpAfterRetries(t *testing.T) {
+	setTusTestSizes(t, 10)
+	fake := &fakeTusServer{patchStatus: http.StatusInternalServerError}
+
+	if err := runTusUpload(t, fake, testContent(10)); err == nil {
+		t.Fatal("tusUpload succeeded, want an error")
+	}
+	if len(fake.patchOffsets) != tusChunkRetries+1 {
+		t.Fatalf("PATCH was requested %d times, want %d", len(fake.patchOffsets), tusChunkRetries+1)
+	}
+}
+
+func TestTusUploadPreconditionFailed(t *testing.T) {
+	setTusTestSizes(t, 10)
+	fake := &fakeTusServer{patchStatus: http.StatusPreconditionFailed}
+
+	if err := runTusUpload(t, fake, testContent(10)); !errors.Is(err, ErrPreconditionFailed) {
+		t.Fatalf("tusUpload = %v, want ErrPreconditionFailed", err)
+	}
+	// a modified file isn't retried
+	if len(fake.patchOffsets) != 1 || fake.heads != 0 {
+		t.Fatalf("PATCH was requested %d times and HEAD %d times, want a single PATCH", len(fake.patchOffsets), fake.heads)
+	}
+}
@@ -2,26 +2,45 @@ package helpers
 
 import (
 	"context"
-	"crypto/tls"
 	"errors"
 	"io"
 	"net/http"
+	"strconv"
 
 	gatewayv1beta1 "github.com/cs3org/go-cs3apis/cs3/gateway/v1beta1"
 	rpcv1beta1 "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
 	providerv1beta1 "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	typesv1beta1 "github.com/cs3org/go-cs3apis/cs3/types/v1beta1"
 	"github.com/owncloud/ocis/v2/ocis-pkg/log"
 )
 
-func UploadFile(ctx context.Context, content io.ReadCloser, ref *providerv1beta1.Reference, gwc gatewayv1beta1.GatewayAPIClient, token string, lockID string, insecure bool, logger log.Logger) error {
+// ErrPreconditionFailed is returned if the file was modified since the etag passed as ifMatch
+var ErrPreconditionFailed = errors.New("precondition failed")
+
+// UploadFile uploads the content to the referenced file. The size of the content is needed for TUS uploads,
+// content of unknown size (-1) is uploaded with a single PUT request.
+func UploadFile(ctx context.Context, content io.ReadCloser, size int64, ref *providerv1beta1.Reference, gwc gatewayv1beta1.GatewayAPIClient, token string, lockID string, ifMatch string, httpClient *http.Client, logger log.Logger) error {
 
 	req := &providerv1beta1.InitiateFileUploadRequest{
 		Ref:    ref,
 		LockId: lockID,
-		// TODO: if-match
-		//Options: &providerv1beta1.InitiateFileUploadRequest_IfMatch{
-		//	IfMatch: "",
-		//},
+	}
+	if size >= 0 {
+		// TUS uploads are created with the final size
+		req.Opaque = &typesv1beta1.Opaque{
+			Map: map[string]*typesv1beta1.OpaqueEntry{
+				"Upload-Length": {
+					Decoder: "plain",
+					Value:   []byte(strconv.FormatInt(size, 10)),
+				},
+			},
+		}
+	}
+	if ifMatch != "" {
+		// only upload if the file wasn't modified in the meantime
+		req.Options = &providerv1beta1.InitiateFileUploadRequest_IfMatch{
+			IfMatch: ifMatch,
+		}
 	}
 
 	resp, err := gwc.InitiateFileUpload(ctx, req)
@@ -34,33 +53,40 @@ func UploadFile(ctx context.Context, content io.ReadCloser, ref *providerv1beta1
 		return err
 	}
 
+	if resp.Status.Code == rpcv1beta1.Code_CODE_FAILED_PRECONDITION && ifMatch != "" {
+		return ErrPreconditionFailed
+	}
+
 	if resp.Status.Code != rpcv1beta1.Code_CODE_OK {
 		return errors.New("status code != CODE_OK")
 	}
 
 	uploadEndpoint := ""
 	uploadToken := ""
+	tusEndpoint := ""
+	tusToken := ""
 
 	for _, proto := range resp.Protocols {
-		if proto.Protocol == "simple" || proto.Protocol == "spaces" {
+		switch proto.Protocol {
+		case "simple", "spaces":
 			uploadEndpoint = proto.UploadEndpoint
 			uploadToken = proto.Token
+		case "tus":
+			tusEndpoint = proto.UploadEndpoint
+			tusToken = proto.Token
 		}
 	}
 
-	if uploadEndpoint == "" {
-		return errors.New("upload endpoint or token is missing")
+	// TUS uploads are sent in chunks, which are retried on failure
+	if tusEndpoint != "" && size > 0 {
+		return tusUpload(ctx, httpClient, tusEndpoint, tusToken, token, content, size, logger)
 	}
 
-	httpClient := http.Client{
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{
-				InsecureSkipVerify: insecure,
-			},
-		},
+	if uploadEndpoint == "" {
+		return errors.New("upload endpoint or token is missing")
 	}
 
-	httpReq, err := http.NewRequest(http.MethodPut, uploadEndpoint, content)
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPut, uploadEndpoint, content)
 	if err != nil {
 		return err
 	}
@@ -81,6 +107,13 @@ func UploadFile(ctx context.Context, content io.ReadCloser, ref *providerv1beta1
 	if err != nil {
 		return err
 	}
+	// the body needs to be read and closed to reuse the connection
+	defer httpResp.Body.Close()
+	_, _ = io.Copy(io.Discard, httpResp.Body)
+
+	if httpResp.StatusCode == http.StatusPreconditionFailed && ifMatch != "" {
+		return ErrPreconditionFailed
+	}
 
 	if httpResp.StatusCode != http.StatusOK {
 		return errors.New("status code was not 200")
@@ -0,0 +1,107 @@
+package lockstore
+
+import (
+	"context"
+	"fmt"
+
+	gatewayv1beta1 "github.com/cs3org/go-cs3apis/cs3/gateway/v1beta1"
+	rpcv1beta1 "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
+	providerv1beta1 "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// CS3Store stores the locks in the storage by using the lock calls of the CS3 gateway
+type CS3Store struct {
+	gwc gatewayv1beta1.GatewayAPIClient
+}
+
+// NewCS3 returns a lock store using the lock calls of the CS3 gateway
+func NewCS3(gwc gatewayv1beta1.GatewayAPIClient) *CS3Store {
+	return &CS3Store{gwc: gwc}
+}
+
+func (s *CS3Store) GetLock(ctx context.Context, ref *providerv1beta1.Reference) (*providerv1beta1.Lock, error) {
+	resp, err := s.gwc.GetLock(ctx, &providerv1beta1.GetLockRequest{
+		Ref: ref,
+	})
+	if err != nil {
+		return nil, fromGRPCError(err)
+	}
+
+	switch resp.Status.Code {
+	case rpcv1beta1.Code_CODE_OK:
+		return resp.Lock, nil
+	case rpcv1beta1.Code_CODE_NOT_FOUND:
+		// the storage reports files without lock as not found
+		return nil, nil
+	default:
+		return nil, fromStatus(resp.Status)
+	}
+}
+
+func (s *CS3Store) SetLock(ctx context.Context, ref *providerv1beta1.Reference, lock *providerv1beta1.Lock) error {
+	resp, err := s.gwc.SetLock(ctx, &providerv1beta1.SetLockRequest{
+		Ref:  ref,
+		Lock: lock,
+	})
+	if err != nil {
+		return fromGRPCError(err)
+	}
+	return fromStatus(resp.Status)
+}
+
+func (s *CS3Store) RefreshLock(ctx context.Context, ref *providerv1beta1.Reference, lock *providerv1beta1.Lock, existingLockID string) error {
+	resp, err := s.gwc.RefreshLock(ctx, &providerv1beta1.RefreshLockRequest{
+		Ref:            ref,
+		Lock:           lock,
+		ExistingLockId: existingLockID,
+	})
+	if err != nil {
+		return fromGRPCError(err)
+	}
+	return fromStatus(resp.Status)
+}
+
+func (s *CS3Store) Unlock(ctx context.Context, ref *providerv1beta1.Reference, lock *providerv1beta1.Lock) error {
+	resp, err := s.gwc.Unlock(ctx, &providerv1beta1.UnlockRequest{
+		Ref:  ref,
+		Lock: lock,
+	})
+	if err != nil {
+		return fromGRPCError(err)
+	}
+	return fromStatus(resp.Status)
+}
+
+func (s *CS3Store) StorageLockID(ctx context.Context, ref *providerv1beta1.Reference, lockID string) string {
+	return lockID
+}
+
+// fromStatus maps a CS3 status to the lock store errors
+func fromStatus(s *rpcv1beta1.Status) error {
+	switch s.Code {
+	case rpcv1beta1.Code_CODE_OK:
+		return nil
+	case rpcv1beta1.Code_CODE_NOT_FOUND:
+		return fmt.Errorf("%w: %s", ErrNotFound, s.Message)
+	case rpcv1beta1.Code_CODE_FAILED_PRECONDITION, rpcv1beta1.Code_CODE_ABORTED, rpcv1beta1.Code_CODE_LOCKED:
+		// the storage uses all of these codes for lock mismatches and missing locks
+		return fmt.Errorf("%w: %s", ErrConflict, s.Message)
+	case rpcv1beta1.Code_CODE_PERMISSION_DENIED:
+		// the storage denies modifications of locks held by other apps or users
+		return fmt.Errorf("%w: %s", ErrPermissionDenied, s.Message)
+	case rpcv1beta1.Code_CODE_UNIMPLEMENTED:
+		return fmt.Errorf("%w: %s", ErrNotSupported, s.Message)
+	default:
+		return fmt.Errorf("status code %s: %s", s.Code.String(), s.Message)
+	}
+}
+
+// fromGRPCError maps a gRPC error to the lock store errors
+func fromGRPCError(err error) error {
+	if status.Code(err) == codes.Unimplemented {
+		return fmt.Errorf("%w: %s", ErrNotSupported, err.Error())
+	}
+	return err
+}
@@ -0,0 +1,114 @@
+package lockstore
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	gatewayv1beta1 "github.com/cs3org/go-cs3apis/cs3/gateway/v1beta1"
+	rpcv1beta1 "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
+	providerv1beta1 "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeLockGateway implements the lock calls of the gateway, all other calls panic
+type fakeLockGateway struct {
+	gatewayv1beta1.GatewayAPIClient
+
+	code  rpcv1beta1.Code
+	err   error
+	lock  *providerv1beta1.Lock
+	calls int
+}
+
+func (g *fakeLockGateway) status() *rpcv1beta1.Status {
+	return &rpcv1beta1.Status{Code: g.code, Message: g.code.String()}
+}
+
+func (g *fakeLockGateway) GetLock(ctx context.Context, req *providerv1beta1.GetLockRequest, opts ...grpc.CallOption) (*providerv1beta1.GetLockResponse, error) {
+	g.calls++
+	if g.err != nil {
+		return nil, g.err
+	}
+	return &providerv1beta1.GetLockResponse{Status: g.status(), Lock: g.lock}, nil
+}
+
+func (g *fakeLockGateway) SetLock(ctx context.Context, req *providerv1beta1.SetLockRequest, opts ...grpc.CallOption) (*providerv1beta1.SetLockResponse, error) {
+	g.calls++
+	if g.err != nil {
+		return nil, g.err
+	}
+	return &providerv1beta1.SetLockResponse{Status: g.status()}, nil
+}
+
+func (g *fakeLockGateway) RefreshLock(ctx context.Context, req *providerv1beta1.RefreshLockRequest, opts ...grpc.CallOption) (*providerv1beta1.RefreshLockResponse, error) {
+	g.calls++
+	if g.err != nil {
+		return nil, g.err
+	}
+	return &providerv1beta1.RefreshLockResponse{Status: g.status()}, nil
+}
+
+func (g *fakeLockGateway) Unlock(ctx context.Context, req *providerv1beta1.UnlockRequest, opts ...grpc.CallOption) (*providerv1beta1.UnlockResponse, error) {
+	g.calls++
+	if g.err != nil {
+		return nil, g.err
+	}
+	return &providerv1beta1.UnlockResponse{Status: g.status()}, nil
+}
+
+func TestCS3StoreGetLock(t *testing.T) {
+	ctx := context.Background()
+	lock := testLock("a", time.Now().Add(time.Hour))
+
+	gwc := &fakeLockGateway{code: rpcv1beta1.Code_CODE_OK, lock: lock}
+	got, err := NewCS3(gwc).GetLock(ctx, testRef("file"))
+	if err != nil || got.GetLockId() != "a" {
+		t.Fatalf("GetLock = %v, %v, want lock a", got, err)
+	}
+
+	// the storage reports unlocked files as not found
+	gwc = &fakeLockGateway{code: rpcv1beta1.Code_CODE_NOT_FOUND}
+	got, err = NewCS3(gwc).GetLock(ctx, testRef("file"))
+	if err != nil || got != nil {
+		t.Fatalf("GetLock of an unlocked file = %v, %v, want nil, nil", got, err)
+	}
+}
+
+func TestCS3StoreErrors(t *testing.T) {
+	ctx := context.Background()
+	lock := testLock("a", time.Now().Add(time.Hour))
+
+	tests := []struct {
+		code rpcv1beta1.Code
+		want error
+	}{
+		{rpcv1beta1.Code_CODE_NOT_FOUND, ErrNotFound},
+		{rpcv1beta1.Code_CODE_FAILED_PRECONDITION, ErrConflict},
+		{rpcv1beta1.Code_CODE_ABORTED, ErrConflict},
+		{rpcv1beta1.Code_CODE_LOCKED, ErrConflict},
+		{rpcv1beta1.Code_CODE_PERMISSION_DENIED, ErrPermissionDenied},
+		{rpcv1beta1.Code_CODE_UNIMPLEMENTED, ErrNotSupported},
+	}
+	for _, tt := range tests {
+		store := NewCS3(&fakeLockGateway{code: tt.code})
+		if err := store.SetLock(ctx, testRef("file"), lock); !errors.Is(err, tt.want) {
+			t.Errorf("SetLock with %s = %v, want %v", tt.code, err, tt.want)
+		}
+		if err := store.RefreshLock(ctx, testRef("file"), lock, ""); !errors.Is(err, tt.want) {
+			t.Errorf("RefreshLock with %s = %v, want %v", tt.code, err, tt.want)
+		}
+		if err := store.Unlock(ctx, testRef("file"), lock); !errors.Is(err, tt.want) {
+			t.Errorf("Unlock with %s = %v, want %v", tt.code, err, tt.want)
+		}
+	}
+
+	// gateways without the lock calls report them as unimplemented
+	store := NewCS3(&fakeLockGateway{err: status.Error(codes.Unimplemented, "unknown method SetLock")})
+	if err := store.SetLock(ctx, testRef("file"), lock); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("SetLock with an unimplemented gRPC method = %v, want ErrNotSupported", err)
+	}
+}
@@ -0,0 +1,101 @@
+package lockstore
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	providerv1beta1 "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	"github.com/owncloud/ocis/v2/ocis-pkg/log"
+)
+
+// FallbackStore uses the primary store and switches to the fallback store for
+// all spaces, where the primary store reports that locks are not supported.
+type FallbackStore struct {
+	primary  Store
+	fallback Store
+	logger   log.Logger
+
+	// spaces that don't support locks in the primary store
+	unsupported sync.Map
+}
+
+// NewFallback returns a lock store that falls back to another store if locks are not supported
+func NewFallback(primary Store, fallback Store, logger log.Logger) *FallbackStore {
+	return &FallbackStore{
+		primary:  primary,
+		fallback: fallback,
+		logger:   logger,
+	}
+}
+
+func (s *FallbackStore) GetLock(ctx context.Context, ref *providerv1beta1.Reference) (*providerv1beta1.Lock, error) {
+	if !s.isUnsupported(ref) {
+		lock, err := s.primary.GetLock(ctx, ref)
+		if !s.markUnsupported(ref, err) {
+			return lock, err
+		}
+	}
+	return s.fallback.GetLock(ctx, ref)
+}
+
+func (s *FallbackStore) SetLock(ctx context.Context, ref *providerv1beta1.Reference, lock *providerv1beta1.Lock) error {
+	if !s.isUnsupported(ref) {
+		err := s.primary.SetLock(ctx, ref, lock)
+		if !s.markUnsupported(ref, err) {
+			return err
+		}
+	}
+	return s.fallback.SetLock(ctx, ref, lock)
+}
+
+func (s *FallbackStore) RefreshLock(ctx context.Context, ref *providerv1beta1.Reference, lock *providerv1beta1.Lock, existingLockID string) error {
+	if !s.isUnsupported(ref) {
+		err := s.primary.RefreshLock(ctx, ref, lock, existingLockID)
+		if !s.markUnsupported(ref, err) {
+			return err
+		}
+	}
+	return s.fallback.RefreshLock(ctx, ref, lock, existingLockID)
+}
+
+func (s *FallbackStore) Unlock(ctx context.Context, ref *providerv1beta1.Reference, lock *providerv1beta1.Lock) error {
+	if !s.isUnsupported(ref) {
+		err := s.primary.Unlock(ctx, ref, lock)
+		if !s.markUnsupported(ref, err) {
+			return err
+		}
+	}
+	return s.fallback.Unlock(ctx, ref, lock)
+}
+
+func (s *FallbackStore) StorageLockID(ctx context.Context, ref *providerv1beta1.Reference, lockID string) string {
+	if s.isUnsupported(ref) {
+		return s.fallback.StorageLockID(ctx, ref, lockID)
+	}
+	return s.primary.StorageLockID(ctx, ref, lockID)
+}
+
+func (s *FallbackStore) isUnsupported(ref *providerv1beta1.Reference) bool {
+	_, ok := s.unsupported.Load(spaceKey(ref))
+	return ok
+}
+
+// markUnsupported remembers the space of the reference if err reports that locks are not supported
+func (s *FallbackStore) markUnsupported(ref *providerv1beta1.Reference, err error) bool {
+	if !errors.Is(err, ErrNotSupported) {
+		return false
+	}
+
+	key := spaceKey(ref)
+	if _, loaded := s.unsupported.LoadOrStore(key, true); !loaded {
+		s.logger.Info().Str("space", key).Msg("LockStore: locks are not supported by the storage, falling back")
+	}
+	return true
+}
+
+// spaceKey returns a key for the space of a file reference
+func spaceKey(ref *providerv1beta1.Reference) string {
+	id := ref.GetResourceId()
+	return id.GetStorageId() + "$" + id.GetSpaceId()
+}
@@ -0,0 +1,65 @@
+package lockstore
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	rpcv1beta1 "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
+	"github.com/owncloud/ocis/v2/ocis-pkg/log"
+)
+
+func TestFallbackStoreUsesPrimary(t *testing.T) {
+	ctx := context.Background()
+	primary := &fakeLockGateway{code: rpcv1beta1.Code_CODE_LOCKED}
+	fallback, err := NewLocal("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewFallback(NewCS3(primary), fallback, log.NopLogger())
+
+	// errors of the primary store other than unsupported locks are returned as they are
+	if err := store.SetLock(ctx, testRef("file"), testLock("a", time.Now().Add(time.Hour))); !errors.Is(err, ErrConflict) {
+		t.Fatalf("SetLock = %v, want ErrConflict of the primary store", err)
+	}
+	if lock, _ := fallback.GetLock(ctx, testRef("file")); lock != nil {
+		t.Fatalf("the fallback store was used for a space with lock support")
+	}
+	if got := store.StorageLockID(ctx, testRef("file"), "a"); got != "a" {
+		t.Fatalf("StorageLockID = %q, want the lock id of the primary store", got)
+	}
+}
+
+func TestFallbackStoreFallsBackPerSpace(t *testing.T) {
+	ctx := context.Background()
+	primary := &fakeLockGateway{code: rpcv1beta1.Code_CODE_UNIMPLEMENTED}
+	fallback, err := NewLocal("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewFallback(NewCS3(primary), fallback, log.NopLogger())
+
+	if err := store.SetLock(ctx, testRef("file"), testLock("a", time.Now().Add(time.Hour))); err != nil {
+		t.Fatalf("SetLock failed: %v", err)
+	}
+	if primary.calls != 1 {
+		t.Fatalf("primary store was called %d times, want 1", primary.calls)
+	}
+
+	// the space is remembered, the primary store isn't asked again
+	lock, err := store.GetLock(ctx, testRef("other-file"))
+	if err != nil || lock != nil {
+		t.Fatalf("GetLock = %v, %v, want nil, nil", lock, err)
+	}
+	lock, err = store.GetLock(ctx, testRef("file"))
+	if err != nil || lock.GetLockId() != "a" {
+		t.Fatalf("GetLock = %v, %v, want lock a of the fallback store", lock, err)
+	}
+	if primary.calls != 1 {
+		t.Fatalf("primary store was called %d times, want 1", primary.calls)
+	}
+	if got := store.StorageLockID(ctx, testRef("file"), "a"); got != "" {
+		t.Fatalf("StorageLockID = %q, want no lock id in the storage", got)
+	}
+}
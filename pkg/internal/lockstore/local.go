@@ -0,0 +1,232 @@
+package lockstore
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	providerv1beta1 "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	protov1 "github.com/golang/protobuf/proto"
+	"github.com/wkloucek/cs3-wopi-server/pkg/internal/atomicfile"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// LocalStore keeps the locks in memory and optionally persists them to a file,
+// so that they survive restarts of the WOPI server. The locks are not visible
+// to other CS3 clients.
+type LocalStore struct {
+	file string
+
+	mu    sync.Mutex
+	locks map[string]*providerv1beta1.Lock
+}
+
+// NewLocal returns a lock store that is local to this WOPI server.
+// If file is empty, the locks are only kept in memory.
+func NewLocal(file string) (*LocalStore, error) {
+	s := &LocalStore{
+		file:  file,
+		locks: make(map[string]*providerv1beta1.Lock),
+	}
+
+	if file == "" {
+		return s, nil
+	}
+
+	content, err := atomicfile.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(content) > 0 {
+		// the locks are protobuf messages, they are stored in their canonical JSON form.
+		// The CS3 API messages are generated with the APIv1 of protobuf and need to be wrapped for protojson.
+		rawLocks := map[string]json.RawMessage{}
+		if err := json.Unmarshal(content, &rawLocks); err != nil {
+			return nil, err
+		}
+		for key, rawLock := range rawLocks {
+			lock := &providerv1beta1.Lock{}
+			if err := protojson.Unmarshal(rawLock, protov1.MessageV2(lock)); err != nil {
+				return nil, err
+			}
+			s.locks[key] = lock
+		}
+	}
+
+	if s.removeExpired() {
+		if err := s.persist(s.locks); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+func (s *LocalStore) GetLock(ctx context.Context, ref *providerv1beta1.Reference) (*providerv1beta1.Lock, error) {
+	key, err := refKey(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	lock := s.currentLock(key)
+	if lock == nil && s.removeExpired() {
+		// the expired locks would otherwise stay in the file until the next change of a lock
+		if err := s.persist(s.locks); err != nil {
+			return nil, err
+		}
+	}
+
+	return lock, nil
+}
+
+func (s *LocalStore) SetLock(ctx context.Context, ref *providerv1beta1.Reference, lock *providerv1beta1.Lock) error {
+	key, err := refKey(ref)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.removeExpired()
+	if s.currentLock(key) != nil {
+		return ErrConflict
+	}
+
+	locks := s.copyLocks()
+	locks[key] = lock
+	return s.commit(locks)
+}
+
+func (s *LocalStore) RefreshLock(ctx context.Context, ref *providerv1beta1.Reference, lock *providerv1beta1.Lock, existingLockID string) error {
+	key, err := refKey(ref)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.removeExpired()
+	current := s.currentLock(key)
+	if current == nil {
+		return ErrConflict
+	}
+
+	expectedLockID := lock.LockId
+	if existingLockID != "" {
+		expectedLockID = existingLockID
+	}
+	if current.LockId != expectedLockID {
+		return ErrConflict
+	}
+
+	locks := s.copyLocks()
+	locks[key] = lock
+	return s.commit(locks)
+}
+
+func (s *LocalStore) Unlock(ctx context.Context, ref *providerv1beta1.Reference, lock *providerv1beta1.Lock) error {
+	key, err := refKey(ref)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.removeExpired()
+	current := s.currentLock(key)
+	if current == nil || current.LockId != lock.LockId {
+		return ErrConflict
+	}
+
+	locks := s.copyLocks()
+	delete(locks, key)
+	return s.commit(locks)
+}
+
+func (s *LocalStore) StorageLockID(ctx context.Context, ref *providerv1beta1.Reference, lockID string) string {
+	// the storage doesn't know about our locks
+	return ""
+}
+
+// currentLock returns the lock for the key if it exists and is not expired.
+// The caller must hold the mutex.
+func (s *LocalStore) currentLock(key string) *providerv1beta1.Lock {
+	lock, ok := s.locks[key]
+	if !ok || expired(lock, time.Now()) {
+		return nil
+	}
+
+	return lock
+}
+
+// removeExpired removes the expired locks and reports if there were any. The removal is
+// persisted with the next change of the locks. The caller must hold the mutex.
+func (s *LocalStore) removeExpired() bool {
+	now := time.Now()
+	removed := false
+	for key, lock := range s.locks {
+		if expired(lock, now) {
+			delete(s.locks, key)
+			removed = true
+		}
+	}
+	return removed
+}
+
+// expired checks if the lock has an expiration before now
+func expired(lock *providerv1beta1.Lock, now time.Time) bool {
+	return lock.Expiration != nil && now.After(time.Unix(int64(lock.Expiration.Seconds), int64(lock.Expiration.Nanos)))
+}
+
+// copyLocks returns a copy of the locks, which can be changed and then committed.
+// The caller must hold the mutex.
+func (s *LocalStore) copyLocks() map[string]*providerv1beta1.Lock {
+	locks := make(map[string]*providerv1beta1.Lock, len(s.locks)+1)
+	for key, lock := range s.locks {
+		locks[key] = lock
+	}
+	return locks
+}
+
+// commit persists the locks and only replaces the locks in memory if that succeeded,
+// so that a failed write doesn't leave the memory and the file out of sync.
+// The caller must hold the mutex.
+func (s *LocalStore) commit(locks map[string]*providerv1beta1.Lock) error {
+	if err := s.persist(locks); err != nil {
+		return err
+	}
+	s.locks = locks
+	return nil
+}
+
+// persist writes the locks to the file, if configured.
+// The caller must hold the mutex.
+func (s *LocalStore) persist(locks map[string]*providerv1beta1.Lock) error {
+	if s.file == "" {
+		return nil
+	}
+
+	rawLocks := make(map[string]json.RawMessage, len(locks))
+	for key, lock := range locks {
+		rawLock, err := protojson.Marshal(protov1.MessageV2(lock))
+		if err != nil {
+			return err
+		}
+		rawLocks[key] = rawLock
+	}
+
+	content, err := json.Marshal(rawLocks)
+	if err != nil {
+		return err
+	}
+
+	return atomicfile.WriteFile(s.file, content)
+}
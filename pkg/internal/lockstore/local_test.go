@@ -0,0 +1,232 @@
+package lockstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	userv1beta1 "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	providerv1beta1 "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	typesv1beta1 "github.com/cs3org/go-cs3apis/cs3/types/v1beta1"
+)
+
+func testRef(opaqueID string) *providerv1beta1.Reference {
+	return &providerv1beta1.Reference{
+		ResourceId: &providerv1beta1.ResourceId{
+			StorageId: "storage",
+			SpaceId:   "space",
+			OpaqueId:  opaqueID,
+		},
+		Path: ".",
+	}
+}
+
+func testLock(lockID string, expiration time.Time) *providerv1beta1.Lock {
+	return &providerv1beta1.Lock{
+		LockId:  lockID,
+		AppName: "WOPI",
+		Type:    providerv1beta1.LockType_LOCK_TYPE_WRITE,
+		User: &userv1beta1.UserId{
+			Idp:      "https://idp.example.com",
+			OpaqueId: "einstein",
+			Type:     userv1beta1.UserType_USER_TYPE_PRIMARY,
+		},
+		Expiration: &typesv1beta1.Timestamp{
+			Seconds: uint64(expiration.Unix()),
+		},
+	}
+}
+
+func TestLocalStoreLockLifecycle(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewLocal("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref := testRef("file")
+	expiration := time.Now().Add(time.Hour)
+
+	if lock, err := store.GetLock(ctx, ref); err != nil || lock != nil {
+		t.Fatalf("GetLock of an unlocked file = %v, %v, want nil, nil", lock, err)
+	}
+
+	if err := store.SetLock(ctx, ref, testLock("a", expiration)); err != nil {
+		t.Fatalf("SetLock failed: %v", err)
+	}
+	if err := store.SetLock(ctx, ref, testLock("b", expiration)); !errors.Is(err, ErrConflict) {
+		t.Fatalf("SetLock of a locked file = %v, want ErrConflict", err)
+	}
+
+	// a refresh with another lock id is a conflict, unless the existing lock id matches
+	if err := store.RefreshLock(ctx, ref, testLock("b", expiration), ""); !errors.Is(err, ErrConflict) {
+		t.Fatalf("RefreshLock with another lock id = %v, want ErrConflict", err)
+	}
+	if err := store.RefreshLock(ctx, ref, testLock("b", expiration), "a"); err != nil {
+		t.Fatalf("RefreshLock with the existing lock id failed: %v", err)
+	}
+
+	lock, err := store.GetLock(ctx, ref)
+	if err != nil || lock.GetLockId() != "b" {
+		t.Fatalf("GetLock = %v, %v, want lock b", lock, err)
+	}
+
+	if err := store.Unlock(ctx, ref, testLock("a", expiration)); !errors.Is(err, ErrConflict) {
+		t.Fatalf("Unlock with another lock id = %v, want ErrConflict", err)
+	}
+	if err := store.Unlock(ctx, ref, testLock("b", expiration)); err != nil {
+		t.Fatalf("Unlock failed: %v", err)
+	}
+	if lock, err := store.GetLock(ctx, ref); err != nil || lock != nil {
+		t.Fatalf("GetLock after Unlock = %v, %v, want nil, nil", lock, err)
+	}
+}
+
+func TestLocalStoreKeysOnResourceID(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewLocal("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// "." and an empty path refer to the resource id itself
+	if err := store.SetLock(ctx, testRef("file"), testLock("a", time.Now().Add(time.Hour))); err != nil {
+		t.Fatal(err)
+	}
+	lock, err := store.GetLock(ctx, &providerv1beta1.Reference{ResourceId: testRef("file").ResourceId})
+	if err != nil || lock.GetLockId() != "a" {
+		t.Fatalf("GetLock without path = %v, %v, want lock a", lock, err)
+	}
+
+	// relative paths can't be keyed, they have to be resolved by a stat
+	pathRef := &providerv1beta1.Reference{ResourceId: testRef("parent").ResourceId, Path: "./file.docx"}
+	if _, err := store.GetLock(ctx, pathRef); !errors.Is(err, ErrUnresolvedReference) {
+		t.Fatalf("GetLock with a relative path = %v, want ErrUnresolvedReference", err)
+	}
+	if err := store.SetLock(ctx, pathRef, testLock("b", time.Now().Add(time.Hour))); !errors.Is(err, ErrUnresolvedReference) {
+		t.Fatalf("SetLock with a relative path = %v, want ErrUnresolvedReference", err)
+	}
+}
+
+func TestLocalStorePersistsLocks(t *testing.T) {
+	ctx := context.Background()
+	file := filepath.Join(t.TempDir(), "locks.json")
+	expiration := time.Now().Add(time.Hour)
+
+	store, err := NewLocal(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetLock(ctx, testRef("file"), testLock("a", expiration)); err != nil {
+		t.Fatal(err)
+	}
+
+	// the locks are stored in the protobuf JSON format
+	content, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rawLocks := map[string]map[string]interface{}{}
+	if err := json.Unmarshal(content, &rawLocks); err != nil {
+		t.Fatal(err)
+	}
+	rawLock, ok := rawLocks["storage$space!file"]
+	if !ok {
+		t.Fatalf("lock file %s doesn't contain the lock", content)
+	}
+	if rawLock["lockId"] != "a" || rawLock["type"] != "LOCK_TYPE_WRITE" {
+		t.Fatalf("lock file %s isn't in the protobuf JSON format", content)
+	}
+
+	reloaded, err := NewLocal(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lock, err := reloaded.GetLock(ctx, testRef("file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lock.GetLockId() != "a" ||
+		lock.GetType() != providerv1beta1.LockType_LOCK_TYPE_WRITE ||
+		lock.GetUser().GetOpaqueId() != "einstein" ||
+		lock.GetExpiration().GetSeconds() != uint64(expiration.Unix()) {
+		t.Fatalf("reloaded lock = %v, want the stored lock", lock)
+	}
+}
+
+func TestLocalStorePersistsExpiredLocks(t *testing.T) {
+	ctx := context.Background()
+	file := filepath.Join(t.TempDir(), "locks.json")
+
+	store, err := NewLocal(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetLock(ctx, testRef("expired"), testLock("a", time.Now().Add(-time.Minute))); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetLock(ctx, testRef("valid"), testLock("b", time.Now().Add(time.Hour))); err != nil {
+		t.Fatal(err)
+	}
+
+	// the lookup of the expired lock removes it from the file
+	if lock, err := store.GetLock(ctx, testRef("expired")); err != nil || lock != nil {
+		t.Fatalf("GetLock of an expired lock = %v, %v, want nil, nil", lock, err)
+	}
+
+	content, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rawLocks := map[string]json.RawMessage{}
+	if err := json.Unmarshal(content, &rawLocks); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := rawLocks["storage$space!expired"]; ok {
+		t.Fatalf("lock file %s still contains the expired lock", content)
+	}
+	if _, ok := rawLocks["storage$space!valid"]; !ok {
+		t.Fatalf("lock file %s lost the valid lock", content)
+	}
+}
+
+func TestLocalStoreKeepsLocksInSyncWithTheFile(t *testing.T) {
+	ctx := context.Background()
+	dir := filepath.Join(t.TempDir(), "locks")
+	if err := os.Mkdir(dir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := NewLocal(filepath.Join(dir, "locks.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetLock(ctx, testRef("file"), testLock("a", time.Now().Add(time.Hour))); err != nil {
+		t.Fatal(err)
+	}
+
+	// the lock file can't be written anymore
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.SetLock(ctx, testRef("other-file"), testLock("b", time.Now().Add(time.Hour))); err == nil {
+		t.Fatal("SetLock succeeded without writing the lock file")
+	}
+	if lock, err := store.GetLock(ctx, testRef("other-file")); err != nil || lock != nil {
+		t.Fatalf("GetLock after a failed SetLock = %v, %v, want nil, nil", lock, err)
+	}
+
+	if err := store.RefreshLock(ctx, testRef("file"), testLock("c", time.Now().Add(time.Hour)), "a"); err == nil {
+		t.Fatal("RefreshLock succeeded without writing the lock file")
+	}
+	if err := store.Unlock(ctx, testRef("file"), testLock("a", time.Now().Add(time.Hour))); err == nil {
+		t.Fatal("Unlock succeeded without writing the lock file")
+	}
+	if lock, err := store.GetLock(ctx, testRef("file")); err != nil || lock.GetLockId() != "a" {
+		t.Fatalf("GetLock after a failed RefreshLock and Unlock = %v, %v, want lock a", lock, err)
+	}
+}
@@ -0,0 +1,52 @@
+package lockstore
+
+import (
+	"context"
+	"errors"
+
+	providerv1beta1 "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+)
+
+var (
+	// ErrConflict is returned if the file is not locked or locked with a different lock id
+	ErrConflict = errors.New("lock conflict")
+	// ErrNotFound is returned if the referenced file doesn't exist
+	ErrNotFound = errors.New("file not found")
+	// ErrPermissionDenied is returned if the lock operation is not permitted, eg. because another app holds the lock
+	ErrPermissionDenied = errors.New("permission denied")
+	// ErrNotSupported is returned if the storage doesn't support locks
+	ErrNotSupported = errors.New("locks not supported")
+	// ErrUnresolvedReference is returned by stores that key the locks on resource ids, if the reference
+	// has a relative path. The caller needs to stat the reference to resolve its resource id first.
+	ErrUnresolvedReference = errors.New("reference is not resolved to a resource id")
+)
+
+// Store manages the WOPI locks on files. The references must point to the file by its
+// resource id, references with a relative path need to be resolved by a stat first.
+type Store interface {
+	// GetLock returns the current lock of the file or nil if the file is not locked
+	GetLock(ctx context.Context, ref *providerv1beta1.Reference) (*providerv1beta1.Lock, error)
+	// SetLock locks the file, it fails with ErrConflict if the file is already locked
+	SetLock(ctx context.Context, ref *providerv1beta1.Reference, lock *providerv1beta1.Lock) error
+	// RefreshLock refreshes the lock of the file. If existingLockID is set, the existing lock
+	// is replaced by the given lock. It fails with ErrConflict if the lock ids don't match.
+	RefreshLock(ctx context.Context, ref *providerv1beta1.Reference, lock *providerv1beta1.Lock, existingLockID string) error
+	// Unlock removes the lock from the file, it fails with ErrConflict if the lock ids don't match
+	Unlock(ctx context.Context, ref *providerv1beta1.Reference, lock *providerv1beta1.Lock) error
+	// StorageLockID returns the lock id that needs to be sent to the storage along with writes.
+	// Locks not held by the storage itself must not be sent, the storage would reject the write.
+	StorageLockID(ctx context.Context, ref *providerv1beta1.Reference, lockID string) string
+}
+
+// refKey returns a stable key for the file of a reference. The key only depends on the resource id,
+// references with a relative path can't be keyed, because they may point to the same file as the id.
+func refKey(ref *providerv1beta1.Reference) (string, error) {
+	if p := ref.GetPath(); p != "" && p != "." {
+		return "", ErrUnresolvedReference
+	}
+	id := ref.GetResourceId()
+	if id.GetOpaqueId() == "" {
+		return "", ErrUnresolvedReference
+	}
+	return id.GetStorageId() + "$" + id.GetSpaceId() + "!" + id.GetOpaqueId(), nil
+}
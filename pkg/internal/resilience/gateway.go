@@ -0,0 +1,82 @@
+package resilience
+
+import (
+	"context"
+	"errors"
+
+	gatewayv1beta1 "github.com/cs3org/go-cs3apis/cs3/gateway/v1beta1"
+	rpcv1beta1 "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
+	providerv1beta1 "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// errStatusUnavailable marks CS3 responses with an unavailable status, they are returned to the caller as they are
+var errStatusUnavailable = errors.New("CS3 status unavailable")
+
+// gatewayClient retries the idempotent calls of the file operations. All other calls are passed through unchanged.
+// InitiateFileUpload isn't retried, an attempt that timed out may have created an upload session already.
+type gatewayClient struct {
+	gatewayv1beta1.GatewayAPIClient
+
+	policy *Policy
+}
+
+// NewGatewayClient wraps the CS3 gateway client with the retries and the circuit breaker of the policy
+func NewGatewayClient(gwc gatewayv1beta1.GatewayAPIClient, policy *Policy) gatewayv1beta1.GatewayAPIClient {
+	return &gatewayClient{
+		GatewayAPIClient: gwc,
+		policy:           policy,
+	}
+}
+
+func (c *gatewayClient) Stat(ctx context.Context, in *providerv1beta1.StatRequest, opts ...grpc.CallOption) (*providerv1beta1.StatResponse, error) {
+	return call(ctx, c.policy, func() (*providerv1beta1.StatResponse, error) {
+		return c.GatewayAPIClient.Stat(ctx, in, opts...)
+	})
+}
+
+func (c *gatewayClient) InitiateFileDownload(ctx context.Context, in *providerv1beta1.InitiateFileDownloadRequest, opts ...grpc.CallOption) (*gatewayv1beta1.InitiateFileDownloadResponse, error) {
+	return call(ctx, c.policy, func() (*gatewayv1beta1.InitiateFileDownloadResponse, error) {
+		return c.GatewayAPIClient.InitiateFileDownload(ctx, in, opts...)
+	})
+}
+
+// call makes an idempotent gateway call with the policy. Transport errors and CS3 responses with an
+// unavailable status are transient, the response of the last attempt is returned to the caller.
+func call[T interface{ GetStatus() *rpcv1beta1.Status }](ctx context.Context, policy *Policy, fn func() (T, error)) (T, error) {
+	var res T
+	err := policy.Do(ctx, GatewayEndpoint, true, func() error {
+		var err error
+		res, err = fn()
+		if err != nil {
+			if transientGRPCError(ctx, err) {
+				return Transient(err)
+			}
+			return err
+		}
+		if res.GetStatus().GetCode() == rpcv1beta1.Code_CODE_UNAVAILABLE {
+			return Transient(errStatusUnavailable)
+		}
+		return nil
+	})
+	if errors.Is(err, errStatusUnavailable) {
+		return res, nil
+	}
+	return res, err
+}
+
+// transientGRPCError reports if the call failed because the gateway couldn't be reached or didn't answer in time
+func transientGRPCError(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		// the caller gave up, eg. the WOPI client closed the connection
+		return false
+	}
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return true
+	default:
+		return false
+	}
+}
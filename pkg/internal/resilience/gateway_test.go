@@ -0,0 +1,113 @@
+package resilience
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	gatewayv1beta1 "github.com/cs3org/go-cs3apis/cs3/gateway/v1beta1"
+	rpcv1beta1 "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
+	providerv1beta1 "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeGateway answers the calls with the errors and the status codes in order, all other calls panic
+type fakeGateway struct {
+	gatewayv1beta1.GatewayAPIClient
+
+	errs  []error
+	codes []rpcv1beta1.Code
+	calls int
+}
+
+func (g *fakeGateway) next() (rpcv1beta1.Code, error) {
+	i := min(g.calls, len(g.codes)-1)
+	g.calls++
+	return g.codes[i], g.errs[i]
+}
+
+func (g *fakeGateway) Stat(ctx context.Context, in *providerv1beta1.StatRequest, opts ...grpc.CallOption) (*providerv1beta1.StatResponse, error) {
+	code, err := g.next()
+	if err != nil {
+		return nil, err
+	}
+	return &providerv1beta1.StatResponse{Status: &rpcv1beta1.Status{Code: code}}, nil
+}
+
+func (g *fakeGateway) InitiateFileUpload(ctx context.Context, in *providerv1beta1.InitiateFileUploadRequest, opts ...grpc.CallOption) (*gatewayv1beta1.InitiateFileUploadResponse, error) {
+	code, err := g.next()
+	if err != nil {
+		return nil, err
+	}
+	return &gatewayv1beta1.InitiateFileUploadResponse{Status: &rpcv1beta1.Status{Code: code}}, nil
+}
+
+func newTestPolicy() *Policy {
+	return NewPolicy(Options{RetryAttempts: 3, RetryBackoff: time.Millisecond, BreakerThreshold: 10, BreakerTimeout: time.Minute})
+}
+
+func TestGatewayClientRetriesUnavailableGateway(t *testing.T) {
+	gwc := &fakeGateway{
+		errs:  []error{status.Error(codes.Unavailable, "connection refused"), nil},
+		codes: []rpcv1beta1.Code{rpcv1beta1.Code_CODE_OK, rpcv1beta1.Code_CODE_OK},
+	}
+
+	res, err := NewGatewayClient(gwc, newTestPolicy()).Stat(context.Background(), &providerv1beta1.StatRequest{})
+	if err != nil || res.GetStatus().GetCode() != rpcv1beta1.Code_CODE_OK || gwc.calls != 2 {
+		t.Fatalf("Stat = %v, %v after %d calls, want CODE_OK after 2 calls", res, err, gwc.calls)
+	}
+}
+
+func TestGatewayClientRetriesUnavailableStatus(t *testing.T) {
+	gwc := &fakeGateway{
+		errs:  []error{nil},
+		codes: []rpcv1beta1.Code{rpcv1beta1.Code_CODE_UNAVAILABLE},
+	}
+
+	// the response of the last attempt is returned to the caller
+	res, err := NewGatewayClient(gwc, newTestPolicy()).Stat(context.Background(), &providerv1beta1.StatRequest{})
+	if err != nil || res.GetStatus().GetCode() != rpcv1beta1.Code_CODE_UNAVAILABLE || gwc.calls != 3 {
+		t.Fatalf("Stat = %v, %v after %d calls, want CODE_UNAVAILABLE after 3 calls", res, err, gwc.calls)
+	}
+}
+
+func TestGatewayClientDoesNotRetryOtherErrors(t *testing.T) {
+	gwc := &fakeGateway{
+		errs:  []error{status.Error(codes.PermissionDenied, "permission denied")},
+		codes: []rpcv1beta1.Code{rpcv1beta1.Code_CODE_OK},
+	}
+
+	_, err := NewGatewayClient(gwc, newTestPolicy()).Stat(context.Background(), &providerv1beta1.StatRequest{})
+	if status.Code(err) != codes.PermissionDenied || gwc.calls != 1 {
+		t.Fatalf("Stat = %v after %d calls, want PermissionDenied after a single call", err, gwc.calls)
+	}
+}
+
+func TestGatewayClientDoesNotRetryUploads(t *testing.T) {
+	gwc := &fakeGateway{
+		errs:  []error{status.Error(codes.Unavailable, "connection refused")},
+		codes: []rpcv1beta1.Code{rpcv1beta1.Code_CODE_OK},
+	}
+
+	// an upload session may have been created by an attempt that timed out
+	_, err := NewGatewayClient(gwc, newTestPolicy()).InitiateFileUpload(context.Background(), &providerv1beta1.InitiateFileUploadRequest{})
+	if status.Code(err) != codes.Unavailable || gwc.calls != 1 {
+		t.Fatalf("InitiateFileUpload = %v after %d calls, want Unavailable after a single call", err, gwc.calls)
+	}
+}
+
+func TestGatewayClientFailsFastWhileOpen(t *testing.T) {
+	policy := NewPolicy(Options{RetryAttempts: 1, BreakerThreshold: 1, BreakerTimeout: time.Minute})
+	gwc := &fakeGateway{
+		errs:  []error{status.Error(codes.Unavailable, "connection refused")},
+		codes: []rpcv1beta1.Code{rpcv1beta1.Code_CODE_OK},
+	}
+	client := NewGatewayClient(gwc, policy)
+
+	_, _ = client.Stat(context.Background(), &providerv1beta1.StatRequest{})
+	if _, err := client.Stat(context.Background(), &providerv1beta1.StatRequest{}); err != ErrCircuitOpen || gwc.calls != 1 {
+		t.Fatalf("Stat while open = %v after %d calls, want ErrCircuitOpen without a call", err, gwc.calls)
+	}
+}
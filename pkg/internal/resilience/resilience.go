@@ -0,0 +1,218 @@
+// Package resilience protects the calls to the CS3 gateway and the data gateway against transient failures.
+// Idempotent calls are retried with exponential backoff and a circuit breaker per endpoint makes the calls
+// fail fast while an endpoint is down.
+package resilience
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	// GatewayEndpoint is the name of the circuit breaker for the CS3 gateway
+	GatewayEndpoint string = "gateway"
+	// DataGatewayEndpoint is the name of the circuit breaker for the data gateway
+	DataGatewayEndpoint string = "data-gateway"
+)
+
+// ErrCircuitOpen is returned without calling the endpoint while its circuit breaker is open
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// Options configures the retries and the circuit breakers of a Policy
+type Options struct {
+	// how often an idempotent call is made before it fails, values below 1 mean one attempt
+	RetryAttempts int
+	// the delay before the first retry, it doubles for every retry
+	RetryBackoff time.Duration
+	// the maximum delay between two retries
+	RetryMaxBackoff time.Duration
+	// the number of consecutive failures that open the circuit breaker, 0 disables the circuit breaker
+	BreakerThreshold int
+	// how long the circuit breaker stays open before a trial call is let through
+	BreakerTimeout time.Duration
+}
+
+// Policy retries calls and keeps a circuit breaker for every endpoint
+type Policy struct {
+	opts Options
+
+	mu       sync.Mutex
+	breakers map[string]*breaker
+}
+
+// NewPolicy returns a new Policy
+func NewPolicy(opts Options) *Policy {
+	return &Policy{
+		opts:     opts,
+		breakers: map[string]*breaker{},
+	}
+}
+
+// Do calls fn until it succeeds, fails with an error that isn't transient or the retry attempts are used up.
+// Calls that aren't idempotent are made only once. Errors are marked as transient with Transient, only transient
+// errors count as failures of the endpoint, calls canceled by the caller don't count at all. The error of the last
+// call is returned without the transient mark.
+func (p *Policy) Do(ctx context.Context, endpoint string, idempotent bool, fn func() error) error {
+	b := p.breaker(endpoint)
+
+	attempts := 1
+	if idempotent {
+		attempts = max(p.opts.RetryAttempts, 1)
+	}
+	delay := p.opts.RetryBackoff
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		if !b.allow(time.Now()) {
+			return ErrCircuitOpen
+		}
+
+		err = fn()
+		if ctx.Err() != nil {
+			// the caller gave up, eg. the WOPI client closed the connection, that says nothing about the endpoint
+			b.release()
+			return unmarkTransient(err)
+		}
+		var transient *transientError
+		if !errors.As(err, &transient) {
+			// the endpoint answered, even if the call failed for another reason
+			b.success()
+			return err
+		}
+		b.failure(time.Now())
+		err = transient.err
+
+		if attempt >= attempts || ctx.Err() != nil {
+			return err
+		}
+
+		if sleepErr := sleepContext(ctx, delay); sleepErr != nil {
+			return err
+		}
+		delay = min(delay*2, max(p.opts.RetryMaxBackoff, p.opts.RetryBackoff))
+	}
+}
+
+// Available reports if calls to the endpoint are let through by its circuit breaker
+func (p *Policy) Available(endpoint string) bool {
+	return p.breaker(endpoint).available(time.Now())
+}
+
+func (p *Policy) breaker(endpoint string) *breaker {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	b, ok := p.breakers[endpoint]
+	if !ok {
+		b = &breaker{
+			threshold: p.opts.BreakerThreshold,
+			timeout:   p.opts.BreakerTimeout,
+		}
+		p.breakers[endpoint] = b
+	}
+	return b
+}
+
+// Transient marks an error as transient, eg. a connection failure, so that the call is retried
+func Transient(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &transientError{err: err}
+}
+
+type transientError struct {
+	err error
+}
+
+func (e *transientError) Error() string {
+	return e.err.Error()
+}
+
+func (e *transientError) Unwrap() error {
+	return e.err
+}
+
+// unmarkTransient returns the error without the transient mark
+func unmarkTransient(err error) error {
+	var transient *transientError
+	if errors.As(err, &transient) {
+		return transient.err
+	}
+	return err
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// breaker is a circuit breaker, which opens after consecutive failures. After the timeout one trial
+// call is let through, it closes the circuit breaker on success and opens it again on failure.
+type breaker struct {
+	threshold int
+	timeout   time.Duration
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	// a trial call is in progress while the circuit breaker is half open
+	trial bool
+}
+
+func (b *breaker) allow(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.threshold <= 0 || b.failures < b.threshold {
+		return true
+	}
+	if b.trial || now.Sub(b.openedAt) < b.timeout {
+		return false
+	}
+	b.trial = true
+	return true
+}
+
+func (b *breaker) available(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.threshold <= 0 || b.failures < b.threshold || (!b.trial && now.Sub(b.openedAt) >= b.timeout)
+}
+
+func (b *breaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = 0
+	b.trial = false
+}
+
+// release ends a trial call without a result, so that the next call can be the trial
+func (b *breaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.trial = false
+}
+
+func (b *breaker) failure(now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	if b.threshold > 0 && b.failures >= b.threshold {
+		b.openedAt = now
+	}
+	b.trial = false
+}
@@ -0,0 +1,202 @@
+package resilience
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+var errUnavailable = errors.New("endpoint unavailable")
+
+func TestPolicyRetriesTransientErrors(t *testing.T) {
+	policy := NewPolicy(Options{RetryAttempts: 3, RetryBackoff: time.Millisecond, RetryMaxBackoff: time.Millisecond})
+
+	calls := 0
+	err := policy.Do(context.Background(), "endpoint", true, func() error {
+		calls++
+		if calls < 3 {
+			return Transient(errUnavailable)
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Fatalf("Do = %v after %d calls, want success after 3 calls", err, calls)
+	}
+
+	// the error of the last attempt is returned without the transient mark
+	calls = 0
+	err = policy.Do(context.Background(), "endpoint", true, func() error {
+		calls++
+		return Transient(errUnavailable)
+	})
+	var transient *transientError
+	if !errors.Is(err, errUnavailable) || errors.As(err, &transient) || calls != 3 {
+		t.Fatalf("Do = %v after %d calls, want the unmarked error after 3 calls", err, calls)
+	}
+}
+
+func TestPolicyDoesNotRetry(t *testing.T) {
+	policy := NewPolicy(Options{RetryAttempts: 3, RetryBackoff: time.Millisecond})
+	errPermanent := errors.New("permanent")
+
+	tests := []struct {
+		name       string
+		idempotent bool
+		err        error
+	}{
+		{"call that isn't idempotent", false, Transient(errUnavailable)},
+		{"error that isn't transient", true, errPermanent},
+	}
+	for _, tt := range tests {
+		calls := 0
+		_ = policy.Do(context.Background(), "endpoint", tt.idempotent, func() error {
+			calls++
+			return tt.err
+		})
+		if calls != 1 {
+			t.Errorf("%s was called %d times, want once", tt.name, calls)
+		}
+	}
+}
+
+func TestPolicyBackoff(t *testing.T) {
+	policy := NewPolicy(Options{RetryAttempts: 4, RetryBackoff: 10 * time.Millisecond, RetryMaxBackoff: 20 * time.Millisecond})
+
+	var calls []time.Time
+	_ = policy.Do(context.Background(), "endpoint", true, func() error {
+		calls = append(calls, time.Now())
+		return Transient(errUnavailable)
+	})
+	if len(calls) != 4 {
+		t.Fatalf("Do made %d calls, want 4", len(calls))
+	}
+
+	// the delay doubles and is capped by the maximum backoff
+	minDelays := []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 20 * time.Millisecond}
+	for i, minDelay := range minDelays {
+		if delay := calls[i+1].Sub(calls[i]); delay < minDelay || delay > minDelay+time.Second {
+			t.Errorf("delay before retry %d = %s, want %s", i+1, delay, minDelay)
+		}
+	}
+}
+
+func TestPolicyStopsRetryingWhenCanceled(t *testing.T) {
+	policy := NewPolicy(Options{RetryAttempts: 5, RetryBackoff: time.Hour})
+	ctx, cancel := context.WithCancel(context.Background())
+
+	calls := 0
+	done := make(chan error)
+	go func() {
+		done <- policy.Do(ctx, "endpoint", true, func() error {
+			calls++
+			return Transient(errUnavailable)
+		})
+	}()
+	cancel()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, errUnavailable) || calls != 1 {
+			t.Fatalf("Do = %v after %d calls, want the error of the first call", err, calls)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Do waited for the backoff of a canceled call")
+	}
+}
+
+func TestPolicyIgnoresCanceledCalls(t *testing.T) {
+	policy := NewPolicy(Options{BreakerThreshold: 1, BreakerTimeout: 50 * time.Millisecond})
+	// the caller gives up during the call
+	doCanceled := func(result error) {
+		ctx, cancel := context.WithCancel(context.Background())
+		_ = policy.Do(ctx, "endpoint", true, func() error {
+			cancel()
+			return result
+		})
+	}
+
+	// a call that fails because the caller gave up doesn't open the circuit breaker
+	doCanceled(Transient(errUnavailable))
+	if !policy.Available("endpoint") {
+		t.Fatal("a canceled call opened the circuit breaker")
+	}
+
+	// open the circuit breaker and wait for the trial call
+	_ = policy.Do(context.Background(), "endpoint", true, func() error { return Transient(errUnavailable) })
+	time.Sleep(60 * time.Millisecond)
+
+	// a canceled trial call neither closes the circuit breaker nor keeps the trial from being made again
+	doCanceled(nil)
+	if !policy.Available("endpoint") {
+		t.Fatal("a canceled trial call blocks the next trial call")
+	}
+	_ = policy.Do(context.Background(), "endpoint", true, func() error { return Transient(errUnavailable) })
+	if policy.Available("endpoint") {
+		t.Fatal("a canceled trial call closed the circuit breaker")
+	}
+}
+
+func TestBreakerOpensAndCloses(t *testing.T) {
+	policy := NewPolicy(Options{BreakerThreshold: 2, BreakerTimeout: 50 * time.Millisecond})
+	fail := func() error { return Transient(errUnavailable) }
+	succeed := func() error { return nil }
+
+	for i := 0; i < 2; i++ {
+		if err := policy.Do(context.Background(), "endpoint", true, fail); !errors.Is(err, errUnavailable) {
+			t.Fatalf("call %d = %v, want the error of the endpoint", i+1, err)
+		}
+	}
+
+	// the circuit breaker is open, the endpoint isn't called
+	calls := 0
+	err := policy.Do(context.Background(), "endpoint", true, func() error {
+		calls++
+		return nil
+	})
+	if !errors.Is(err, ErrCircuitOpen) || calls != 0 || policy.Available("endpoint") {
+		t.Fatalf("call while open = %v after %d calls, want ErrCircuitOpen without a call", err, calls)
+	}
+	// other endpoints have their own circuit breaker
+	if !policy.Available("other") {
+		t.Fatal("the circuit breaker of another endpoint is open")
+	}
+
+	// after the timeout a failed trial call opens the circuit breaker again
+	time.Sleep(60 * time.Millisecond)
+	if !policy.Available("endpoint") {
+		t.Fatal("the circuit breaker doesn't let a trial call through after the timeout")
+	}
+	if err := policy.Do(context.Background(), "endpoint", true, fail); !errors.Is(err, errUnavailable) {
+		t.Fatalf("trial call = %v, want the error of the endpoint", err)
+	}
+	if err := policy.Do(context.Background(), "endpoint", true, succeed); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("call after the failed trial call = %v, want ErrCircuitOpen", err)
+	}
+
+	// a successful trial call closes the circuit breaker
+	time.Sleep(60 * time.Millisecond)
+	if err := policy.Do(context.Background(), "endpoint", true, succeed); err != nil {
+		t.Fatalf("trial call = %v, want success", err)
+	}
+	if err := policy.Do(context.Background(), "endpoint", true, succeed); err != nil {
+		t.Fatalf("call after the successful trial call = %v, want success", err)
+	}
+}
+
+func TestBreakerLetsOneTrialCallThrough(t *testing.T) {
+	b := &breaker{threshold: 1, timeout: time.Minute}
+	now := time.Now()
+	b.failure(now)
+
+	if b.allow(now) {
+		t.Fatal("the open circuit breaker let a call through")
+	}
+	later := now.Add(time.Minute)
+	if !b.allow(later) {
+		t.Fatal("the circuit breaker doesn't let a trial call through after the timeout")
+	}
+	if b.allow(later) || b.available(later) {
+		t.Fatal("the half open circuit breaker let a second call through during the trial call")
+	}
+}
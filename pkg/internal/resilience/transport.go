@@ -0,0 +1,77 @@
+package resilience
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// transport retries idempotent requests to the data gateway and counts the failures of all requests
+type transport struct {
+	next   http.RoundTripper
+	policy *Policy
+}
+
+// NewTransport wraps the transport of the data gateway HTTP client with the retries and the circuit breaker of the policy.
+// Only GET and HEAD requests are retried, because the bodies of uploads can't be sent again.
+func NewTransport(next http.RoundTripper, policy *Policy) http.RoundTripper {
+	return &transport{
+		next:   next,
+		policy: policy,
+	}
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	idempotent := (req.Method == http.MethodGet || req.Method == http.MethodHead) && (req.Body == nil || req.Body == http.NoBody)
+
+	var res *http.Response
+	err := t.policy.Do(ctx, DataGatewayEndpoint, idempotent, func() error {
+		if res != nil {
+			// the body of the failed attempt needs to be read and closed to reuse the connection
+			_, _ = io.Copy(io.Discard, res.Body)
+			res.Body.Close()
+		}
+
+		var err error
+		res, err = t.next.RoundTrip(req)
+		if err != nil {
+			res = nil
+			if ctx.Err() != nil {
+				// the caller gave up, eg. the WOPI client closed the connection
+				return err
+			}
+			return Transient(err)
+		}
+
+		switch res.StatusCode {
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return Transient(&statusError{code: res.StatusCode})
+		default:
+			return nil
+		}
+	})
+
+	var statusErr *statusError
+	if errors.As(err, &statusErr) {
+		// the response of the last attempt is passed to the caller
+		return res, nil
+	}
+	if err != nil && res != nil {
+		// the circuit breaker opened after a failed attempt
+		_, _ = io.Copy(io.Discard, res.Body)
+		res.Body.Close()
+		return nil, err
+	}
+	return res, err
+}
+
+// statusError marks data gateway responses, which indicate that the data gateway or the storage is unavailable
+type statusError struct {
+	code int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("data gateway returned status code %d", e.code)
+}
@@ -0,0 +1,121 @@
+package resilience
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// statusServer answers requests with the status codes in order, the last one is repeated
+type statusServer struct {
+	mu    sync.Mutex
+	codes []int
+	calls int
+}
+
+func (s *statusServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	code := s.codes[min(s.calls, len(s.codes)-1)]
+	s.calls++
+	s.mu.Unlock()
+
+	http.Error(w, http.StatusText(code), code)
+}
+
+func newTestClient(t *testing.T, server *statusServer) (*http.Client, string) {
+	t.Helper()
+
+	httpServer := httptest.NewServer(server)
+	t.Cleanup(httpServer.Close)
+
+	return &http.Client{
+		Transport: NewTransport(http.DefaultTransport, newTestPolicy()),
+	}, httpServer.URL
+}
+
+func TestTransportRetriesUnavailableDataGateway(t *testing.T) {
+	for _, code := range []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout} {
+		server := &statusServer{codes: []int{code, http.StatusOK}}
+		client, url := newTestClient(t, server)
+
+		res, err := client.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK || server.calls != 2 {
+			t.Errorf("GET after %d = %d after %d calls, want 200 after 2 calls", code, res.StatusCode, server.calls)
+		}
+	}
+}
+
+func TestTransportReturnsTheLastResponse(t *testing.T) {
+	server := &statusServer{codes: []int{http.StatusServiceUnavailable}}
+	client, url := newTestClient(t, server)
+
+	res, err := client.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusServiceUnavailable || server.calls != 3 {
+		t.Fatalf("GET = %d after %d calls, want 503 after 3 calls", res.StatusCode, server.calls)
+	}
+}
+
+func TestTransportDoesNotRetry(t *testing.T) {
+	tests := []struct {
+		name string
+		code int
+		req  func(url string) *http.Request
+	}{
+		{"PUT", http.StatusServiceUnavailable, func(url string) *http.Request {
+			req, _ := http.NewRequest(http.MethodPut, url, strings.NewReader("content"))
+			return req
+		}},
+		{"PATCH", http.StatusBadGateway, func(url string) *http.Request {
+			req, _ := http.NewRequest(http.MethodPatch, url, strings.NewReader("content"))
+			return req
+		}},
+		{"GET with another error", http.StatusInternalServerError, func(url string) *http.Request {
+			req, _ := http.NewRequest(http.MethodGet, url, nil)
+			return req
+		}},
+	}
+	for _, tt := range tests {
+		server := &statusServer{codes: []int{tt.code, http.StatusOK}}
+		client, url := newTestClient(t, server)
+
+		res, err := client.Do(tt.req(url))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != tt.code || server.calls != 1 {
+			t.Errorf("%s = %d after %d calls, want %d after a single call", tt.name, res.StatusCode, server.calls, tt.code)
+		}
+	}
+}
+
+func TestTransportFailsFastWhileOpen(t *testing.T) {
+	server := &statusServer{codes: []int{http.StatusServiceUnavailable}}
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	policy := NewPolicy(Options{RetryAttempts: 1, BreakerThreshold: 1, BreakerTimeout: time.Minute})
+	client := &http.Client{Transport: NewTransport(http.DefaultTransport, policy)}
+
+	res, err := client.Get(httpServer.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if _, err := client.Get(httpServer.URL); !errors.Is(err, ErrCircuitOpen) || server.calls != 1 {
+		t.Fatalf("GET while open = %v after %d calls, want ErrCircuitOpen without a call", err, server.calls)
+	}
+}
@@ -0,0 +1,203 @@
+package templates
+
+import (
+	"context"
+	"errors"
+	"path"
+	"sort"
+	"sync"
+
+	gatewayv1beta1 "github.com/cs3org/go-cs3apis/cs3/gateway/v1beta1"
+	userv1beta1 "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	rpcv1beta1 "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
+	providerv1beta1 "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	typesv1beta1 "github.com/cs3org/go-cs3apis/cs3/types/v1beta1"
+)
+
+const (
+	// how deep sub folders of the library folder are indexed, they are used as categories
+	maxLibraryDepth int = 3
+	// the maximum number of templates in the index
+	maxLibraryTemplates int = 1000
+	// the maximum number of users, whose indexes are cached
+	maxLibraryUsers int = 1000
+)
+
+// ErrLibraryNotFound is returned if the library folder doesn't exist or the user has no access to it
+var ErrLibraryNotFound = errors.New("template library not found")
+
+// Template is a template in the library
+type Template struct {
+	Id *providerv1beta1.ResourceId
+	// the file name of the template, including the extension
+	Name string
+	// the path of the sub folder the template is in, empty for templates in the library folder
+	Category string
+	Size     uint64
+	Mtime    *typesv1beta1.Timestamp
+}
+
+// Library indexes the templates in a CS3 folder, eg. company letterheads maintained in a space.
+// Users may have access to different parts of the folder, so every user has an own index.
+// It is rebuilt when the etag of the folder changes.
+type Library struct {
+	gwc    gatewayv1beta1.GatewayAPIClient
+	folder *providerv1beta1.ResourceId
+
+	mu      sync.Mutex
+	indexes map[string]*libraryIndex
+}
+
+// libraryIndex are the templates a user has access to
+type libraryIndex struct {
+	etag      string
+	templates []Template
+}
+
+// NewLibrary returns a new Library for the folder
+func NewLibrary(gwc gatewayv1beta1.GatewayAPIClient, folder *providerv1beta1.ResourceId) *Library {
+	return &Library{
+		gwc:     gwc,
+		folder:  folder,
+		indexes: map[string]*libraryIndex{},
+	}
+}
+
+// Templates returns the templates of the library the user has access to, sorted by category and name.
+// The context needs to carry the token of the user. The index of anonymous users, eg. of public links, isn't cached.
+func (l *Library) Templates(ctx context.Context, user *userv1beta1.UserId) ([]Template, error) {
+	// the stat also checks that the user has access to the library
+	statRes, err := l.gwc.Stat(ctx, &providerv1beta1.StatRequest{
+		Ref: &providerv1beta1.Reference{
+			ResourceId: l.folder,
+			Path:       ".",
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	switch statRes.Status.Code {
+	case rpcv1beta1.Code_CODE_OK:
+	case rpcv1beta1.Code_CODE_NOT_FOUND, rpcv1beta1.Code_CODE_PERMISSION_DENIED:
+		return nil, ErrLibraryNotFound
+	default:
+		return nil, errors.New("stat of the template library failed: " + statRes.Status.Code.String())
+	}
+
+	// etags of folders change with every change of their content
+	key := userKey(user)
+	etag := statRes.Info.Etag
+	l.mu.Lock()
+	index, ok := l.indexes[key]
+	l.mu.Unlock()
+	if ok && key != "" && etag != "" && index.etag == etag {
+		return index.templates, nil
+	}
+
+	// the index is built without holding the lock, so that a slow storage doesn't block the requests of
+	// other users. Concurrent requests of the same user may build the same index, the last one wins.
+	templates := []Template{}
+	if err := l.index(ctx, statRes.Info.Id, "", 1, &templates); err != nil {
+		return nil, err
+	}
+	sort.Slice(templates, func(i, j int) bool {
+		if templates[i].Category != templates[j].Category {
+			return templates[i].Category < templates[j].Category
+		}
+		return templates[i].Name < templates[j].Name
+	})
+
+	if key != "" && etag != "" {
+		l.mu.Lock()
+		l.store(key, &libraryIndex{etag: etag, templates: templates})
+		l.mu.Unlock()
+	}
+
+	return templates, nil
+}
+
+// store caches the index of the user, the indexes of other users for older etags are dropped.
+// The caller needs to hold l.mu.
+func (l *Library) store(key string, index *libraryIndex) {
+	for otherKey, other := range l.indexes {
+		if other.etag != index.etag {
+			delete(l.indexes, otherKey)
+		}
+	}
+	if len(l.indexes) >= maxLibraryUsers {
+		l.indexes = map[string]*libraryIndex{}
+	}
+	l.indexes[key] = index
+}
+
+// userKey returns the key of the index of the user or an empty string for anonymous users
+func userKey(user *userv1beta1.UserId) string {
+	if user.GetOpaqueId() == "" {
+		return ""
+	}
+	return user.GetIdp() + "!" + user.GetOpaqueId()
+}
+
+// Template returns the template with the id or nil if it isn't in the library or the user has no access to it
+func (l *Library) Template(ctx context.Context, user *userv1beta1.UserId, id *providerv1beta1.ResourceId) (*Template, error) {
+	templates, err := l.Templates(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, template := range templates {
+		if template.Id.GetStorageId() == id.GetStorageId() &&
+			template.Id.GetSpaceId() == id.GetSpaceId() &&
+			template.Id.GetOpaqueId() == id.GetOpaqueId() {
+			return &template, nil
+		}
+	}
+	return nil, nil
+}
+
+// index adds the files in the folder and its sub folders to the templates
+func (l *Library) index(ctx context.Context, folder *providerv1beta1.ResourceId, category string, depth int, templates *[]Template) error {
+	listRes, err := l.gwc.ListContainer(ctx, &providerv1beta1.ListContainerRequest{
+		Ref: &providerv1beta1.Reference{
+			ResourceId: folder,
+			Path:       ".",
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	if listRes.Status.Code != rpcv1beta1.Code_CODE_OK {
+		return errors.New("list container of the template library failed: " + listRes.Status.Code.String())
+	}
+
+	for _, info := range listRes.Infos {
+		if len(*templates) >= maxLibraryTemplates {
+			return nil
+		}
+
+		name := path.Base(info.Path)
+
+		switch info.Type {
+		case providerv1beta1.ResourceType_RESOURCE_TYPE_FILE:
+			*templates = append(*templates, Template{
+				Id:       info.Id,
+				Name:     name,
+				Category: category,
+				Size:     info.Size,
+				Mtime:    info.Mtime,
+			})
+
+		case providerv1beta1.ResourceType_RESOURCE_TYPE_CONTAINER:
+			if depth >= maxLibraryDepth {
+				continue
+			}
+			if err := l.index(ctx, info.Id, path.Join(category, name), depth+1, templates); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
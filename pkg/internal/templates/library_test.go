@@ -0,0 +1,184 @@
+package templates
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	gatewayv1beta1 "github.com/cs3org/go-cs3apis/cs3/gateway/v1beta1"
+	userv1beta1 "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	rpcv1beta1 "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
+	providerv1beta1 "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// the header of the CS3 token, the fake gateway uses the user name as token
+const tokenHeader string = "x-access-token"
+
+// fakeGateway is a library folder with a sub folder, which only einstein has access to. All other calls panic.
+type fakeGateway struct {
+	gatewayv1beta1.GatewayAPIClient
+
+	etag  string
+	lists int
+	// listing the folder for the user "slow" waits until blocked is closed
+	blocked chan struct{}
+}
+
+func testID(opaqueID string) *providerv1beta1.ResourceId {
+	return &providerv1beta1.ResourceId{StorageId: "storage", SpaceId: "space", OpaqueId: opaqueID}
+}
+
+func tokenFromCtx(ctx context.Context) string {
+	md, _ := metadata.FromOutgoingContext(ctx)
+	if tokens := md.Get(tokenHeader); len(tokens) > 0 {
+		return tokens[0]
+	}
+	return ""
+}
+
+func (g *fakeGateway) Stat(ctx context.Context, req *providerv1beta1.StatRequest, opts ...grpc.CallOption) (*providerv1beta1.StatResponse, error) {
+	return &providerv1beta1.StatResponse{
+		Status: &rpcv1beta1.Status{Code: rpcv1beta1.Code_CODE_OK},
+		Info: &providerv1beta1.ResourceInfo{
+			Id:   req.Ref.ResourceId,
+			Type: providerv1beta1.ResourceType_RESOURCE_TYPE_CONTAINER,
+			Etag: g.etag,
+		},
+	}, nil
+}
+
+func (g *fakeGateway) ListContainer(ctx context.Context, req *providerv1beta1.ListContainerRequest, opts ...grpc.CallOption) (*providerv1beta1.ListContainerResponse, error) {
+	g.lists++
+	if tokenFromCtx(ctx) == "slow" && g.blocked != nil {
+		<-g.blocked
+	}
+
+	infos := []*providerv1beta1.ResourceInfo{}
+	switch req.Ref.ResourceId.OpaqueId {
+	case "library":
+		infos = append(infos, &providerv1beta1.ResourceInfo{
+			Id:   testID("letter"),
+			Path: "./letter.docx",
+			Type: providerv1beta1.ResourceType_RESOURCE_TYPE_FILE,
+		})
+		if tokenFromCtx(ctx) == "einstein" {
+			infos = append(infos, &providerv1beta1.ResourceInfo{
+				Id:   testID("board"),
+				Path: "./Board",
+				Type: providerv1beta1.ResourceType_RESOURCE_TYPE_CONTAINER,
+			})
+		}
+	case "board":
+		infos = append(infos, &providerv1beta1.ResourceInfo{
+			Id:   testID("minutes"),
+			Path: "./minutes.docx",
+			Type: providerv1beta1.ResourceType_RESOURCE_TYPE_FILE,
+		})
+	}
+
+	return &providerv1beta1.ListContainerResponse{
+		Status: &rpcv1beta1.Status{Code: rpcv1beta1.Code_CODE_OK},
+		Infos:  infos,
+	}, nil
+}
+
+func userCtx(name string) (context.Context, *userv1beta1.UserId) {
+	ctx := metadata.AppendToOutgoingContext(context.Background(), tokenHeader, name)
+	return ctx, &userv1beta1.UserId{Idp: "https://idp.example.com", OpaqueId: name}
+}
+
+func TestLibraryIndexesPerUser(t *testing.T) {
+	gwc := &fakeGateway{etag: "1"}
+	library := NewLibrary(gwc, testID("library"))
+
+	einsteinCtx, einstein := userCtx("einstein")
+	templates, err := library.Templates(einsteinCtx, einstein)
+	if err != nil || len(templates) != 2 {
+		t.Fatalf("Templates of einstein = %v, %v, want both templates", templates, err)
+	}
+
+	// the templates einstein has access to must not be returned to other users
+	marieCtx, marie := userCtx("marie")
+	templates, err = library.Templates(marieCtx, marie)
+	if err != nil || len(templates) != 1 || templates[0].Name != "letter.docx" {
+		t.Fatalf("Templates of marie = %v, %v, want only letter.docx", templates, err)
+	}
+	if template, err := library.Template(marieCtx, marie, testID("minutes")); err != nil || template != nil {
+		t.Fatalf("Template of marie = %v, %v, want no access to minutes.docx", template, err)
+	}
+	if template, err := library.Template(einsteinCtx, einstein, testID("minutes")); err != nil || template == nil {
+		t.Fatalf("Template of einstein = %v, %v, want minutes.docx", template, err)
+	}
+}
+
+func TestLibraryCachesIndexes(t *testing.T) {
+	gwc := &fakeGateway{etag: "1"}
+	library := NewLibrary(gwc, testID("library"))
+	ctx, marie := userCtx("marie")
+
+	for i := 0; i < 2; i++ {
+		if _, err := library.Templates(ctx, marie); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if gwc.lists != 1 {
+		t.Fatalf("the library folder was listed %d times, want once", gwc.lists)
+	}
+
+	// a change of the folder rebuilds the index
+	gwc.etag = "2"
+	if _, err := library.Templates(ctx, marie); err != nil {
+		t.Fatal(err)
+	}
+	if gwc.lists != 2 {
+		t.Fatalf("the library folder was listed %d times after a change, want twice", gwc.lists)
+	}
+
+	// anonymous users aren't cached
+	anonymousCtx, _ := userCtx("")
+	for i := 0; i < 2; i++ {
+		if _, err := library.Templates(anonymousCtx, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if gwc.lists != 4 {
+		t.Fatalf("the library folder was listed %d times, want every time for anonymous users", gwc.lists)
+	}
+}
+
+func TestLibraryIndexingDoesNotBlockOtherUsers(t *testing.T) {
+	gwc := &fakeGateway{etag: "1", blocked: make(chan struct{})}
+	library := NewLibrary(gwc, testID("library"))
+	marieCtx, marie := userCtx("marie")
+	if _, err := library.Templates(marieCtx, marie); err != nil {
+		t.Fatal(err)
+	}
+
+	slowCtx, slow := userCtx("slow")
+	indexed := make(chan struct{})
+	go func() {
+		defer close(indexed)
+		if _, err := library.Templates(slowCtx, slow); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	cached := make(chan struct{})
+	go func() {
+		defer close(cached)
+		if _, err := library.Templates(marieCtx, marie); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	select {
+	case <-cached:
+	case <-time.After(5 * time.Second):
+		t.Error("the cached index of marie wasn't returned while the index of another user was built")
+	}
+	close(gwc.blocked)
+	<-indexed
+	<-cached
+}
@@ -0,0 +1,79 @@
+// Package templates provides the content of new documents. Built-in blank templates are available for the
+// OOXML and ODF formats, admins can override them or add templates for other formats in a directory.
+// Organizations can maintain a library of templates, eg. letterheads, in a CS3 folder.
+package templates
+
+import (
+	"embed"
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// the templates are named "blank" followed by the file extension, eg. "blank.docx"
+const templateName string = "blank"
+
+//go:embed blank
+var builtinTemplates embed.FS
+
+// file extensions may only contain letters and digits, so that they can't escape the template directory
+var validExtension = regexp.MustCompile(`^\.[a-z0-9]+$`)
+
+// Store returns the blank template for a file extension
+type Store struct {
+	dir string
+}
+
+// New returns a new Store. Templates in the directory take precedence over the built-in templates,
+// an empty directory means that only the built-in templates are used.
+func New(dir string) *Store {
+	return &Store{
+		dir: dir,
+	}
+}
+
+// Template returns the blank template for the file extension, eg. ".docx".
+// If there is no template for the file extension, nil is returned.
+func (s *Store) Template(ext string) ([]byte, error) {
+	ext = strings.ToLower(ext)
+	if !validExtension.MatchString(ext) {
+		return nil, nil
+	}
+
+	if s.dir != "" {
+		content, err := os.ReadFile(filepath.Join(s.dir, templateName+ext))
+		if err == nil {
+			return content, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+
+	content, err := builtinTemplates.ReadFile(path.Join("blank", templateName+ext))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	return content, err
+}
+
+// Exists reports if there is a blank template for the file extension
+func (s *Store) Exists(ext string) bool {
+	ext = strings.ToLower(ext)
+	if !validExtension.MatchString(ext) {
+		return false
+	}
+
+	if s.dir != "" {
+		if info, err := os.Stat(filepath.Join(s.dir, templateName+ext)); err == nil && info.Mode().IsRegular() {
+			return true
+		}
+	}
+
+	_, err := fs.Stat(builtinTemplates, path.Join("blank", templateName+ext))
+	return err == nil
+}
@@ -0,0 +1,100 @@
+package userinfostore
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/wkloucek/cs3-wopi-server/pkg/internal/atomicfile"
+)
+
+// LocalStore keeps the UserInfo strings in memory and optionally persists them to a file,
+// so that they survive restarts of the WOPI server.
+type LocalStore struct {
+	file string
+
+	mu        sync.RWMutex
+	userInfos map[string]string
+}
+
+// NewLocal returns a UserInfo store that is local to this WOPI server.
+// If file is empty, the UserInfo strings are only kept in memory.
+func NewLocal(file string) (*LocalStore, error) {
+	s := &LocalStore{
+		file:      file,
+		userInfos: make(map[string]string),
+	}
+
+	if file == "" {
+		return s, nil
+	}
+
+	content, err := atomicfile.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(content) > 0 {
+		if err := json.Unmarshal(content, &s.userInfos); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+func (s *LocalStore) GetUserInfo(ctx context.Context, userID string, fileRef string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.userInfos[key(userID, fileRef)], nil
+}
+
+func (s *LocalStore) SetUserInfo(ctx context.Context, userID string, fileRef string, userInfo string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	userInfos := s.copyUserInfos()
+	if userInfo == "" {
+		delete(userInfos, key(userID, fileRef))
+	} else {
+		userInfos[key(userID, fileRef)] = userInfo
+	}
+	return s.commit(userInfos)
+}
+
+// copyUserInfos returns a copy of the UserInfo strings, which can be changed and then committed.
+// The caller must hold the mutex.
+func (s *LocalStore) copyUserInfos() map[string]string {
+	userInfos := make(map[string]string, len(s.userInfos)+1)
+	for key, userInfo := range s.userInfos {
+		userInfos[key] = userInfo
+	}
+	return userInfos
+}
+
+// commit persists the UserInfo strings and only replaces the ones in memory if that succeeded,
+// so that a failed write doesn't leave the memory and the file out of sync.
+// The caller must hold the mutex.
+func (s *LocalStore) commit(userInfos map[string]string) error {
+	if err := s.persist(userInfos); err != nil {
+		return err
+	}
+	s.userInfos = userInfos
+	return nil
+}
+
+// persist writes the UserInfo strings to the file, if configured.
+// The caller must hold the mutex.
+func (s *LocalStore) persist(userInfos map[string]string) error {
+	if s.file == "" {
+		return nil
+	}
+
+	content, err := json.Marshal(userInfos)
+	if err != nil {
+		return err
+	}
+
+	return atomicfile.WriteFile(s.file, content)
+}
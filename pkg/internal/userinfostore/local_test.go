@@ -0,0 +1,99 @@
+package userinfostore
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLocalStoreUserInfo(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewLocal("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if userInfo, err := store.GetUserInfo(ctx, "einstein", "file"); err != nil || userInfo != "" {
+		t.Fatalf("GetUserInfo without a stored UserInfo = %q, %v, want empty", userInfo, err)
+	}
+
+	if err := store.SetUserInfo(ctx, "einstein", "file", "first start done"); err != nil {
+		t.Fatal(err)
+	}
+	if userInfo, err := store.GetUserInfo(ctx, "einstein", "file"); err != nil || userInfo != "first start done" {
+		t.Fatalf("GetUserInfo = %q, %v, want the stored UserInfo", userInfo, err)
+	}
+
+	// the UserInfo is kept per user and file
+	if userInfo, err := store.GetUserInfo(ctx, "marie", "file"); err != nil || userInfo != "" {
+		t.Fatalf("GetUserInfo of another user = %q, %v, want empty", userInfo, err)
+	}
+	if userInfo, err := store.GetUserInfo(ctx, "einstein", "other-file"); err != nil || userInfo != "" {
+		t.Fatalf("GetUserInfo of another file = %q, %v, want empty", userInfo, err)
+	}
+
+	// an empty UserInfo removes the stored one
+	if err := store.SetUserInfo(ctx, "einstein", "file", ""); err != nil {
+		t.Fatal(err)
+	}
+	if userInfo, err := store.GetUserInfo(ctx, "einstein", "file"); err != nil || userInfo != "" {
+		t.Fatalf("GetUserInfo after removing the UserInfo = %q, %v, want empty", userInfo, err)
+	}
+}
+
+func TestLocalStorePersistsUserInfos(t *testing.T) {
+	ctx := context.Background()
+	file := filepath.Join(t.TempDir(), "user-infos.json")
+
+	store, err := NewLocal(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetUserInfo(ctx, "einstein", "file", "first start done"); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := NewLocal(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userInfo, err := reloaded.GetUserInfo(ctx, "einstein", "file"); err != nil || userInfo != "first start done" {
+		t.Fatalf("reloaded GetUserInfo = %q, %v, want the stored UserInfo", userInfo, err)
+	}
+}
+
+func TestLocalStoreKeepsUserInfosInSyncWithTheFile(t *testing.T) {
+	ctx := context.Background()
+	dir := filepath.Join(t.TempDir(), "user-infos")
+	if err := os.Mkdir(dir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := NewLocal(filepath.Join(dir, "user-infos.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetUserInfo(ctx, "einstein", "file", "first start done"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the file can't be written anymore
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.SetUserInfo(ctx, "einstein", "other-file", "first start done"); err == nil {
+		t.Fatal("SetUserInfo succeeded without writing the file")
+	}
+	if userInfo, err := store.GetUserInfo(ctx, "einstein", "other-file"); err != nil || userInfo != "" {
+		t.Fatalf("GetUserInfo after a failed SetUserInfo = %q, %v, want empty", userInfo, err)
+	}
+
+	if err := store.SetUserInfo(ctx, "einstein", "file", ""); err == nil {
+		t.Fatal("SetUserInfo succeeded without writing the file")
+	}
+	if userInfo, err := store.GetUserInfo(ctx, "einstein", "file"); err != nil || userInfo != "first start done" {
+		t.Fatalf("GetUserInfo after a failed removal = %q, %v, want the stored UserInfo", userInfo, err)
+	}
+}
@@ -0,0 +1,18 @@
+package userinfostore
+
+import (
+	"context"
+)
+
+// Store keeps the UserInfo strings, that WOPI clients store per user and file
+type Store interface {
+	// GetUserInfo returns the UserInfo of the user for the file or an empty string, if none was stored
+	GetUserInfo(ctx context.Context, userID string, fileRef string) (string, error)
+	// SetUserInfo stores the UserInfo of the user for the file
+	SetUserInfo(ctx context.Context, userID string, fileRef string, userInfo string) error
+}
+
+// key returns the key of the UserInfo of a user for a file
+func key(userID string, fileRef string) string {
+	return userID + "/" + fileRef
+}